@@ -0,0 +1,76 @@
+package osinredis
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// clientCache is a bounded LRU cache of encoded clients, keyed by their Redis
+// key. Values are kept encoded so that every hit decodes a fresh copy.
+type clientCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type clientCacheEntry struct {
+	key     string
+	value   []byte
+	expires time.Time
+}
+
+func newClientCache(size int, ttl time.Duration) *clientCache {
+	return &clientCache{
+		size:    size,
+		ttl:     ttl,
+		order:   list.New(),
+		entries: make(map[string]*list.Element),
+	}
+}
+
+func (c *clientCache) get(key string, now time.Time) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := elem.Value.(*clientCacheEntry)
+	if !now.Before(entry.expires) {
+		c.remove(elem)
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return entry.value, true
+}
+
+func (c *clientCache) put(key string, value []byte, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+	c.entries[key] = c.order.PushFront(&clientCacheEntry{key: key, value: value, expires: now.Add(c.ttl)})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *clientCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		c.remove(elem)
+	}
+}
+
+func (c *clientCache) remove(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.entries, elem.Value.(*clientCacheEntry).key)
+}
@@ -0,0 +1,67 @@
+package osinredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// ClientMeta holds registration details of a client that osin.Client does not
+// carry, such as those shown by a management UI. It is stored apart from the
+// client and is never read by the osin.Storage methods.
+type ClientMeta struct {
+	DisplayName       string
+	AllowedGrantTypes []string
+	RedirectURIs      []string
+	CreatedAt         time.Time
+	// Secrets are former secrets of the client that VerifyClientSecret
+	// still accepts, as kept by RotateClientSecret.
+	Secrets []ClientSecret
+}
+
+// SetClientMeta stores the metadata of the client with the given ID. It
+// expires with the client when WithClientTTL is in use, and is removed by
+// DeleteClient.
+func (s *Storage) SetClientMeta(ctx context.Context, id string, meta *ClientMeta) (err error) {
+	ctx, done := s.begin(ctx, "SetClientMeta")
+	defer done(&err)
+	return s.setClientMeta(ctx, id, meta)
+}
+
+func (s *Storage) setClientMeta(ctx context.Context, id string, meta *ClientMeta) error {
+	payload, err := s.encode(meta)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode client meta")
+	}
+
+	return s.retry(ctx, func() error {
+		return s.pool.Set(ctx, s.makeKey("client_meta", id), payload, s.clientTTL).Err()
+	})
+}
+
+// GetClientMeta gets the metadata of the client with the given ID.
+func (s *Storage) GetClientMeta(ctx context.Context, id string) (_ *ClientMeta, err error) {
+	ctx, done := s.begin(ctx, "GetClientMeta")
+	defer done(&err)
+	return s.getClientMeta(ctx, id)
+}
+
+func (s *Storage) getClientMeta(ctx context.Context, id string) (*ClientMeta, error) {
+	var raw []byte
+	err := s.retry(ctx, func() (err error) {
+		raw, err = s.pool.Get(ctx, s.makeKey("client_meta", id)).Bytes()
+		return err
+	})
+	if errors.Is(err, redis.Nil) || (err == nil && len(raw) == 0) {
+		return nil, errors.Wrap(ErrNotFound, "unable to GET client meta")
+	}
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to GET client meta")
+	}
+
+	var meta ClientMeta
+	err = s.decode(raw, &meta)
+	return &meta, errors.Wrap(err, "failed to decode client meta")
+}
@@ -0,0 +1,97 @@
+package osinredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+)
+
+// ClientSecret is a secret of a client accepted until ExpiresAt, or forever
+// if ExpiresAt is zero.
+type ClientSecret struct {
+	Secret    string
+	ExpiresAt time.Time
+}
+
+// RotateClientSecret sets the secret of the client with the given ID to
+// newSecret, and keeps accepting its old secret in VerifyClientSecret for
+// oldGrace, by adding it to the Secrets of its ClientMeta. Secrets that have
+// expired are dropped from the metadata at the same time.
+//
+// The client must be an *osin.DefaultClient or have a SetSecret(string)
+// method. RotateClientSecret fails with ErrVersionConflict if the client is
+// changed while it is rotated, leaving the client and its metadata as they
+// were.
+func (s *Storage) RotateClientSecret(ctx context.Context, id, newSecret string, oldGrace time.Duration) (err error) {
+	ctx, done := s.begin(ctx, "RotateClientSecret")
+	defer done(&err)
+	client, version, err := s.getClientWithVersion(ctx, id)
+	if err != nil {
+		return err
+	}
+	oldSecret := client.GetSecret()
+	switch c := client.(type) {
+	case *osin.DefaultClient:
+		c.Secret = newSecret
+	case interface{ SetSecret(string) }:
+		c.SetSecret(newSecret)
+	default:
+		return errors.Errorf("osinredis: unable to set the secret of client type %T", client)
+	}
+
+	meta, err := s.getClientMeta(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		meta, err = &ClientMeta{}, nil
+	}
+	if err != nil {
+		return err
+	}
+	now := s.clock.Now()
+	secrets := meta.Secrets[:0]
+	for _, secret := range meta.Secrets {
+		if secret.ExpiresAt.IsZero() || now.Before(secret.ExpiresAt) {
+			secrets = append(secrets, secret)
+		}
+	}
+	if oldGrace > 0 && oldSecret != "" {
+		secrets = append(secrets, ClientSecret{Secret: oldSecret, ExpiresAt: now.Add(oldGrace)})
+	}
+	meta.Secrets = secrets
+
+	// The metadata is written with the client, so neither changes if the
+	// client was changed meanwhile.
+	return s.updateClientCAS(ctx, client, version, meta)
+}
+
+// VerifyClientSecret checks secret against the secret of the client with the
+// given ID and the former secrets in its ClientMeta that have not expired,
+// failing with ErrSecretMismatch if it matches none.
+func (s *Storage) VerifyClientSecret(ctx context.Context, id, secret string) (err error) {
+	ctx, done := s.begin(ctx, "VerifyClientSecret")
+	defer done(&err)
+	client, err := s.reader().getClient(ctx, id)
+	if err != nil {
+		return err
+	}
+	meta, err := s.getClientMeta(ctx, id)
+	if errors.Is(err, ErrNotFound) {
+		meta, err = &ClientMeta{}, nil
+	}
+	if err != nil {
+		return err
+	}
+
+	matched := SecureCompare(secret, client.GetSecret())
+	now := s.clock.Now()
+	for _, candidate := range meta.Secrets {
+		if candidate.ExpiresAt.IsZero() || now.Before(candidate.ExpiresAt) {
+			matched = SecureCompare(secret, candidate.Secret) || matched
+		}
+	}
+	if !matched {
+		return errors.Wrapf(ErrSecretMismatch, "client %s", id)
+	}
+	return nil
+}
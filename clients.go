@@ -0,0 +1,391 @@
+package osinredis
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// ListClients returns every stored client. It iterates the keyspace with SCAN,
+// so it does not block the server, but it visits every key under the prefix.
+func (s *Storage) ListClients(ctx context.Context) (_ []osin.Client, err error) {
+	ctx, done := s.begin(ctx, "ListClients")
+	defer done(&err)
+
+	var (
+		clients []osin.Client
+		cursor  uint64
+	)
+	sk := &skipper{policy: s.scanErrorPolicy}
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, errors.Wrap(err, "unable to SCAN clients")
+		}
+		page, next, err := s.scanClients(ctx, cursor, s.scanCount, sk)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, page...)
+		if next == 0 {
+			return clients, sk.err()
+		}
+		cursor = next
+	}
+}
+
+// ScanClients returns one page of clients starting at cursor, along with the
+// cursor of the next page, which is 0 once the iteration is complete. count is
+// passed to SCAN as a hint of how many keys to visit; zero uses the Redis
+// default. As with SCAN, a page may be empty before the iteration is complete.
+func (s *Storage) ScanClients(ctx context.Context, cursor uint64, count int64) (_ []osin.Client, _ uint64, err error) {
+	ctx, done := s.begin(ctx, "ScanClients")
+	defer done(&err)
+	sk := &skipper{policy: s.scanErrorPolicy}
+	clients, next, err := s.scanClients(ctx, cursor, count, sk)
+	if err != nil {
+		return nil, 0, err
+	}
+	return clients, next, sk.err()
+}
+
+// CreateClientsError reports the clients CreateClients failed to store, by
+// their index in the clients passed to it.
+type CreateClientsError struct {
+	Errors map[int]error
+}
+
+func (e *CreateClientsError) Error() string {
+	indexes := e.indexes()
+	msgs := make([]string, len(indexes))
+	for i, index := range indexes {
+		msgs[i] = fmt.Sprintf("client %d: %v", index, e.Errors[index])
+	}
+	return "osinredis: unable to create clients: " + strings.Join(msgs, "; ")
+}
+
+// Unwrap returns the errors of the individual clients.
+func (e *CreateClientsError) Unwrap() []error {
+	indexes := e.indexes()
+	errs := make([]error, len(indexes))
+	for i, index := range indexes {
+		errs[i] = e.Errors[index]
+	}
+	return errs
+}
+
+// Is reports whether the error of one of the clients matches target. It lets
+// errors.Is match them on toolchains before Go 1.20, which do not look
+// through Unwrap() []error.
+func (e *CreateClientsError) Is(target error) bool {
+	for _, err := range e.Unwrap() {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of the clients that matches target, as errors.As
+// does, for the same reason as Is.
+func (e *CreateClientsError) As(target interface{}) bool {
+	for _, err := range e.Unwrap() {
+		if errors.As(err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func (e *CreateClientsError) indexes() []int {
+	indexes := make([]int, 0, len(e.Errors))
+	for index := range e.Errors {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
+
+// CreateClients stores the given clients in a single round trip, overwriting
+// any existing ones with the same IDs; of clients sharing an ID, the last one
+// is kept. Clients that fail do not prevent the others from being stored;
+// they are reported by a *CreateClientsError.
+func (s *Storage) CreateClients(ctx context.Context, clients []osin.Client) (err error) {
+	ctx, done := s.begin(ctx, "CreateClients")
+	defer done(&err)
+
+	if err := putClientScript.Load(ctx, s.pool).Err(); err != nil {
+		return errors.Wrap(err, "unable to load client script")
+	}
+	failed := make(map[int]error)
+	pipe := s.pool.Pipeline()
+	cmds := make(map[int]*redis.Cmd, len(clients))
+	for i, client := range clients {
+		if client.GetId() == "" {
+			failed[i] = ErrInvalidClientID
+			continue
+		}
+		payload, err := s.encode(client)
+		if err != nil {
+			failed[i] = errors.Wrapf(err, "failed to encode client %s", client.GetId())
+			continue
+		}
+		cmds[i] = putClientScript.EvalSha(ctx, pipe, s.clientKeys(client.GetId()), payload, s.clientTTL.Milliseconds(), "")
+	}
+	if len(cmds) > 0 {
+		pipe.Exec(ctx)
+	}
+
+	for i, cmd := range cmds {
+		id := clients[i].GetId()
+		if s.clientCache != nil {
+			s.clientCache.invalidate(s.makeKey("client", id))
+		}
+		if err := cmd.Err(); err != nil {
+			failed[i] = errors.Wrapf(err, "unable to SET client %s", id)
+		}
+	}
+	if len(failed) > 0 {
+		return &CreateClientsError{Errors: failed}
+	}
+	return nil
+}
+
+// GetClients loads the clients with the given IDs in a single round trip. The
+// result has one entry per ID, in the same order, which is nil for clients
+// that do not exist.
+func (s *Storage) GetClients(ctx context.Context, ids []string) (_ []osin.Client, err error) {
+	ctx, done := s.begin(ctx, "GetClients")
+	defer done(&err)
+	var clients []osin.Client
+	err = s.retry(ctx, func() (err error) {
+		clients, err = s.getClients(ctx, ids)
+		return err
+	})
+	return clients, err
+}
+
+func (s *Storage) getClients(ctx context.Context, ids []string) ([]osin.Client, error) {
+	if len(ids) == 0 {
+		return []osin.Client{}, nil
+	}
+
+	keys := make([]string, len(ids))
+	for i, id := range ids {
+		keys[i] = s.makeKey("client", id)
+	}
+
+	values, err := s.pool.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to MGET clients")
+	}
+
+	clients := make([]osin.Client, len(values))
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok || raw == "" {
+			continue
+		}
+		if clients[i], err = s.decodeClient([]byte(raw)); err != nil {
+			return nil, err
+		}
+	}
+	return clients, nil
+}
+
+func (s *Storage) scanClients(ctx context.Context, cursor uint64, count int64, sk *skipper) ([]osin.Client, uint64, error) {
+	client, err := s.keyspaceClient(ctx)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to SCAN clients")
+	}
+	keys, next, err := client.Scan(ctx, cursor, s.scanPattern("client"), count).Result()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to SCAN clients")
+	}
+	if len(keys) == 0 {
+		return nil, next, nil
+	}
+
+	values, err := s.pool.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "unable to MGET clients")
+	}
+
+	clients := make([]osin.Client, 0, len(values))
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			// The key expired or was deleted after the SCAN.
+			continue
+		}
+		client, err := s.decodeClient([]byte(raw))
+		if err != nil {
+			if err := sk.skip(keys[i], keyError(keys[i], err)); err != nil {
+				return nil, 0, err
+			}
+			continue
+		}
+		clients = append(clients, client)
+	}
+	return clients, next, nil
+}
+
+// putClientScript stores the client ARGV[1] at KEYS[1] and bumps its version
+// at KEYS[2], both expiring after ARGV[2] milliseconds unless that is 0. If
+// ARGV[3] is not empty, it does so only if the version is ARGV[3], and returns
+// -1 otherwise. If KEYS[3] is given, the client metadata ARGV[4] is stored
+// there along with the client. It returns the new version.
+var putClientScript = redis.NewScript(`
+if ARGV[3] ~= "" and tonumber(redis.call("GET", KEYS[2]) or "0") ~= tonumber(ARGV[3]) then
+	return -1
+end
+local ttl = tonumber(ARGV[2])
+local function set(key, value)
+	if ttl > 0 then
+		redis.call("SET", key, value, "PX", ttl)
+	else
+		redis.call("SET", key, value)
+	end
+end
+set(KEYS[1], ARGV[1])
+if KEYS[3] then
+	set(KEYS[3], ARGV[4])
+end
+local version = redis.call("INCR", KEYS[2])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[2], ttl)
+end
+return version
+`)
+
+// clientKeys returns the keys of putClientScript for the client id.
+func (s *Storage) clientKeys(id string) []string {
+	return []string{s.makeKey("client", id), s.makeKey("client_version", id)}
+}
+
+// GetClientWithVersion gets a client by ID along with its version, to pass to
+// UpdateClientCAS. The version is bumped by every write of the client.
+func (s *Storage) GetClientWithVersion(ctx context.Context, id string) (_ osin.Client, _ int64, err error) {
+	ctx, done := s.begin(ctx, "GetClientWithVersion")
+	defer done(&err)
+	return s.getClientWithVersion(ctx, id)
+}
+
+func (s *Storage) getClientWithVersion(ctx context.Context, id string) (osin.Client, int64, error) {
+	key := s.makeKey("client", id)
+	pipe := s.pool.TxPipeline()
+	get := pipe.Get(ctx, key)
+	version := pipe.Get(ctx, s.makeKey("client_version", id))
+	pipe.Exec(ctx)
+
+	raw, err := get.Bytes()
+	if errors.Is(err, redis.Nil) || (err == nil && len(raw) == 0) {
+		return nil, 0, keyError(key, errors.Wrap(ErrNotFound, "unable to GET client"))
+	}
+	if err != nil {
+		return nil, 0, keyError(key, errors.Wrap(err, "unable to GET client"))
+	}
+	n, err := readVersion(version)
+	if err != nil {
+		return nil, 0, err
+	}
+	client, err := s.decodeClient(raw)
+	return client, n, keyError(key, err)
+}
+
+// UpdateClientCAS updates a client only if its version is still
+// expectedVersion, as returned by GetClientWithVersion, and fails with
+// ErrVersionConflict otherwise. An expectedVersion of 0 matches a client
+// that was never stored.
+func (s *Storage) UpdateClientCAS(ctx context.Context, client osin.Client, expectedVersion int64) (err error) {
+	ctx, done := s.begin(ctx, "UpdateClientCAS")
+	defer done(&err)
+	return s.updateClientCAS(ctx, client, expectedVersion, nil)
+}
+
+// updateClientCAS is UpdateClientCAS storing meta, if not nil, along with the
+// client.
+func (s *Storage) updateClientCAS(ctx context.Context, client osin.Client, expectedVersion int64, meta *ClientMeta) error {
+	if client.GetId() == "" {
+		return ErrInvalidClientID
+	}
+	payload, err := s.encode(client)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode client")
+	}
+
+	keys := s.clientKeys(client.GetId())
+	args := []interface{}{payload, s.clientTTL.Milliseconds(), expectedVersion}
+	if meta != nil {
+		metaPayload, err := s.encode(meta)
+		if err != nil {
+			return errors.Wrap(err, "failed to encode client meta")
+		}
+		keys = append(keys, s.makeKey("client_meta", client.GetId()))
+		args = append(args, metaPayload)
+	}
+	version, err := putClientScript.Run(ctx, s.pool, keys, args...).Int64()
+	if s.clientCache != nil {
+		s.clientCache.invalidate(keys[0])
+	}
+	if err != nil {
+		return keyError(keys[0], err)
+	}
+	if version < 0 {
+		return errors.Wrapf(ErrVersionConflict, "client is not at version %d", expectedVersion)
+	}
+	return nil
+}
+
+// readVersion returns the version read by cmd, which is 0 for a client that
+// was never stored.
+func readVersion(cmd *redis.StringCmd) (int64, error) {
+	n, err := cmd.Int64()
+	if errors.Is(err, redis.Nil) {
+		return 0, nil
+	}
+	return n, errors.Wrap(err, "unable to GET client version")
+}
+
+// DisableClient suspends the client with the given ID. The client and its
+// tokens are kept, but the storage does not enforce the flag: check
+// IsClientDisabled before authorizing a request, e.g.
+//
+//	if ar := server.HandleAuthorizeRequest(resp, r); ar != nil {
+//		disabled, err := storage.IsClientDisabled(r.Context(), ar.Client.GetId())
+//		ar.Authorized = err == nil && !disabled && userApproved(r)
+//		server.FinishAuthorizeRequest(resp, r, ar)
+//	}
+//
+// and likewise for access requests.
+func (s *Storage) DisableClient(ctx context.Context, id string) (err error) {
+	ctx, done := s.begin(ctx, "DisableClient")
+	defer done(&err)
+	err = s.retry(ctx, func() error {
+		return s.pool.Set(ctx, s.makeKey("client_disabled", id), "1", 0).Err()
+	})
+	return errors.Wrap(err, "unable to disable client")
+}
+
+// EnableClient lifts the suspension of the client with the given ID.
+func (s *Storage) EnableClient(ctx context.Context, id string) (err error) {
+	ctx, done := s.begin(ctx, "EnableClient")
+	defer done(&err)
+	return errors.Wrap(s.pool.Del(ctx, s.makeKey("client_disabled", id)).Err(), "unable to enable client")
+}
+
+// IsClientDisabled reports whether the client with the given ID is suspended.
+func (s *Storage) IsClientDisabled(ctx context.Context, id string) (_ bool, err error) {
+	ctx, done := s.begin(ctx, "IsClientDisabled")
+	defer done(&err)
+	var n int64
+	err = s.retry(ctx, func() (err error) {
+		n, err = s.pool.Exists(ctx, s.makeKey("client_disabled", id)).Result()
+		return err
+	})
+	return n > 0, errors.Wrap(err, "unable to check client")
+}
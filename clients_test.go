@@ -0,0 +1,427 @@
+package osinredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func createTestClients(t *testing.T, storage *Storage, n int) {
+	for i := 0; i < n; i++ {
+		client := newClient()
+		client.Id = fmt.Sprintf("client%d", i)
+		assert.NoError(t, storage.CreateClient(client))
+	}
+}
+
+func TestListClientsEmpty(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	clients, err := storage.ListClients(context.Background())
+	assert.NoError(t, err)
+	assert.Empty(t, clients)
+}
+
+func TestListClients(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	// Keys in other namespaces or under another prefix must not be listed.
+	assert.NoError(t, storage.SaveAccess(newAccessData(newAuthorizeData(client))))
+	assert.NoError(t, New(pool, "other").CreateClient(newClient()))
+
+	clients, err := storage.ListClients(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, []osin.Client{client}, clients)
+}
+
+func TestScanClientsPaging(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	createTestClients(t, storage, 25)
+
+	ctx := context.Background()
+	seen := make(map[string]bool)
+	var cursor uint64
+	for {
+		clients, next, err := storage.ScanClients(ctx, cursor, 10)
+		assert.NoError(t, err)
+		for _, client := range clients {
+			seen[client.GetId()] = true
+		}
+		if next == 0 {
+			break
+		}
+		cursor = next
+	}
+	assert.Len(t, seen, 25)
+
+	clients, err := storage.ListClients(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 25)
+}
+
+func TestGetClients(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	createTestClients(t, storage, 2)
+
+	clients, err := storage.GetClients(context.Background(), []string{"client1", "missing", "client0"})
+	assert.NoError(t, err)
+	assert.Len(t, clients, 3)
+	assert.Equal(t, "client1", clients[0].GetId())
+	assert.Nil(t, clients[1])
+	assert.Equal(t, "client0", clients[2].GetId())
+
+	clients, err = storage.GetClients(context.Background(), nil)
+	assert.NoError(t, err)
+	assert.Empty(t, clients)
+}
+
+type scopedClient struct {
+	osin.DefaultClient
+	Scopes []string
+}
+
+func TestWithClientFactory(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithClientFactory(func() osin.Client { return &scopedClient{} }))
+	client := &scopedClient{DefaultClient: *newClient(), Scopes: []string{"read"}}
+	assert.NoError(t, storage.CreateClient(client))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	accessData := newAccessData(newAuthorizeData(newClient()))
+	accessData.Client = client
+	accessData.AuthorizeData.Client = client
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, client, loadData.Client)
+}
+
+func TestDisableClient(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	disabled, err := storage.IsClientDisabled(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.False(t, disabled)
+
+	assert.NoError(t, storage.DisableClient(ctx, client.GetId()))
+	disabled, err = storage.IsClientDisabled(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.True(t, disabled)
+
+	_, err = storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.EnableClient(ctx, client.GetId()))
+	disabled, err = storage.IsClientDisabled(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.False(t, disabled)
+
+	assert.NoError(t, storage.DisableClient(ctx, client.GetId()))
+	assert.NoError(t, storage.DeleteClient(client))
+	disabled, err = storage.IsClientDisabled(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.False(t, disabled)
+}
+
+func TestClientMeta(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	_, err := storage.GetClientMeta(ctx, client.GetId())
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	meta := &ClientMeta{
+		DisplayName:       "Dashboard",
+		AllowedGrantTypes: []string{"authorization_code", "refresh_token"},
+		RedirectURIs:      []string{"http://localhost/", "http://localhost/callback"},
+		CreatedAt:         time.Now().Round(0),
+	}
+	assert.NoError(t, storage.SetClientMeta(ctx, client.GetId(), meta))
+
+	metaFound, err := storage.GetClientMeta(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, meta, metaFound)
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	assert.NoError(t, storage.DeleteClient(client))
+	_, err = storage.GetClientMeta(ctx, client.GetId())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestWithClientCache(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	hook := &roundTripHook{}
+	redisClient := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	redisClient.AddHook(hook)
+	defer redisClient.Close()
+
+	clock := &stepClock{now: time.Now()}
+	storage := New(redisClient, "test123", WithClientCache(1, time.Minute), WithClock(clock))
+	createTestClients(t, storage, 2)
+
+	getClient := func(id string) (osin.Client, int) {
+		before := hook.n
+		client, err := storage.GetClient(id)
+		assert.NoError(t, err)
+		return client, hook.n - before
+	}
+
+	_, trips := getClient("client0")
+	assert.Equal(t, 1, trips)
+	client, trips := getClient("client0")
+	assert.Equal(t, 0, trips)
+	assert.Equal(t, "client0", client.GetId())
+
+	// Hits are decoded afresh, so callers cannot alter the cached client.
+	client.(*osin.DefaultClient).Secret = "changed"
+	client, _ = getClient("client0")
+	assert.Equal(t, "secret", client.GetSecret())
+
+	updated := newClient()
+	updated.Id, updated.Secret = "client0", "rotated"
+	assert.NoError(t, storage.UpdateClient(updated))
+	client, trips = getClient("client0")
+	assert.Equal(t, 1, trips)
+	assert.Equal(t, "rotated", client.GetSecret())
+
+	// The cache holds a single client, so loading another evicts client0.
+	getClient("client1")
+	_, trips = getClient("client0")
+	assert.Equal(t, 1, trips)
+
+	clock.now = clock.now.Add(time.Minute)
+	_, trips = getClient("client0")
+	assert.Equal(t, 1, trips)
+
+	assert.NoError(t, storage.DeleteClient(updated))
+	_, err := storage.GetClient("client0")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = storage.GetClientContext(ctx, "client0")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestCreateClients(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	createTestClients(t, storage, 1)
+
+	overwritten := newClient()
+	overwritten.Id, overwritten.Secret = "client0", "rotated"
+	added := newClient()
+	added.Id = "client1"
+	broken := newClient()
+	broken.Id = "broken"
+	broken.UserData = make(chan int)
+
+	err := storage.CreateClients(ctx, []osin.Client{overwritten, &osin.DefaultClient{}, added, broken, &osin.DefaultClient{}})
+	var createErr *CreateClientsError
+	assert.True(t, errors.As(err, &createErr))
+	assert.Len(t, createErr.Errors, 3)
+	assert.ErrorIs(t, createErr.Errors[1], ErrInvalidClientID)
+	assert.ErrorIs(t, createErr.Errors[4], ErrInvalidClientID)
+	assert.Contains(t, createErr.Errors, 3)
+	assert.Contains(t, err.Error(), "broken")
+	assert.True(t, createErr.Is(ErrInvalidClientID))
+	assert.False(t, createErr.Is(ErrNotFound))
+
+	for _, client := range []*osin.DefaultClient{overwritten, added} {
+		clientFound, err := storage.GetClient(client.GetId())
+		assert.NoError(t, err)
+		assert.Equal(t, client, clientFound)
+	}
+	_, err = storage.GetClient("broken")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.NoError(t, storage.CreateClients(ctx, []osin.Client{added}))
+	assert.NoError(t, storage.CreateClients(ctx, nil))
+
+	// Of clients sharing an ID the last one is kept, and each is reported.
+	first := newClient()
+	first.Id, first.Secret = "client2", "first"
+	last := newClient()
+	last.Id, last.Secret = "client2", "last"
+	duplicateBroken := newClient()
+	duplicateBroken.Id = "client2"
+	duplicateBroken.UserData = make(chan int)
+	err = storage.CreateClients(ctx, []osin.Client{duplicateBroken, first, last})
+	assert.True(t, errors.As(err, &createErr))
+	assert.Len(t, createErr.Errors, 1)
+	assert.Contains(t, createErr.Errors, 0)
+	clientFound, err := storage.GetClient("client2")
+	assert.NoError(t, err)
+	assert.Equal(t, last, clientFound)
+}
+
+func TestUpdateClientCAS(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	found, version, err := storage.GetClientWithVersion(ctx, client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, client, found)
+	assert.EqualValues(t, 1, version)
+
+	client.Secret = "first"
+	assert.NoError(t, storage.UpdateClientCAS(ctx, client, version))
+
+	// A second writer holding the old version loses.
+	stale := newClient()
+	stale.Secret = "second"
+	assert.ErrorIs(t, storage.UpdateClientCAS(ctx, stale, version), ErrVersionConflict)
+
+	found, version, err = storage.GetClientWithVersion(ctx, client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, "first", found.GetSecret())
+	assert.EqualValues(t, 2, version)
+
+	// Plain updates bump the version too.
+	assert.NoError(t, storage.UpdateClient(client))
+	assert.ErrorIs(t, storage.UpdateClientCAS(ctx, client, version), ErrVersionConflict)
+
+	_, _, err = storage.GetClientWithVersion(ctx, "notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// A version read before a deletion does not match the client recreated
+	// under the same ID.
+	recreated := newClient()
+	recreated.Id = "recreated"
+	assert.NoError(t, storage.CreateClient(recreated))
+	_, version, err = storage.GetClientWithVersion(ctx, recreated.Id)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.DeleteClient(recreated))
+	assert.NoError(t, storage.CreateClient(recreated))
+	assert.ErrorIs(t, storage.UpdateClientCAS(ctx, recreated, version), ErrVersionConflict)
+	assert.ErrorIs(t, storage.UpdateClientCAS(ctx, recreated, 0), ErrVersionConflict)
+}
+
+func TestRotateClientSecret(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	clock := &stepClock{now: time.Now()}
+	storage := New(pool, "test123", WithClock(clock))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	assert.NoError(t, storage.VerifyClientSecret(ctx, client.GetId(), "secret"))
+	assert.ErrorIs(t, storage.VerifyClientSecret(ctx, client.GetId(), "new"), ErrSecretMismatch)
+
+	meta := &ClientMeta{DisplayName: "Dashboard"}
+	assert.NoError(t, storage.SetClientMeta(ctx, client.GetId(), meta))
+	assert.NoError(t, storage.RotateClientSecret(ctx, client.GetId(), "new", time.Hour))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, "new", clientFound.GetSecret())
+	metaFound, err := storage.GetClientMeta(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, "Dashboard", metaFound.DisplayName)
+	assert.NoError(t, storage.VerifyClientSecret(ctx, client.GetId(), "new"))
+	assert.NoError(t, storage.VerifyClientSecret(ctx, client.GetId(), "secret"))
+	assert.ErrorIs(t, storage.VerifyClientSecret(ctx, client.GetId(), "other"), ErrSecretMismatch)
+
+	clock.now = clock.now.Add(time.Hour)
+	assert.ErrorIs(t, storage.VerifyClientSecret(ctx, client.GetId(), "secret"), ErrSecretMismatch)
+	assert.NoError(t, storage.VerifyClientSecret(ctx, client.GetId(), "new"))
+
+	assert.NoError(t, storage.RotateClientSecret(ctx, client.GetId(), "newer", 0))
+	metaFound, err = storage.GetClientMeta(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Empty(t, metaFound.Secrets)
+	assert.ErrorIs(t, storage.VerifyClientSecret(ctx, client.GetId(), "new"), ErrSecretMismatch)
+
+	assert.ErrorIs(t, storage.RotateClientSecret(ctx, "unknown", "new", time.Hour), ErrNotFound)
+	assert.ErrorIs(t, storage.VerifyClientSecret(ctx, "unknown", "new"), ErrNotFound)
+}
+
+// racingUpdateHook updates the client before the first script it sees runs,
+// as a concurrent writer would.
+type racingUpdateHook struct {
+	storage *Storage
+	client  osin.Client
+	fired   bool
+}
+
+func (h *racingUpdateHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *racingUpdateHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if name := cmd.Name(); !h.fired && (name == "evalsha" || name == "eval") {
+			h.fired = true
+			if err := h.storage.UpdateClient(h.client); err != nil {
+				return err
+			}
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *racingUpdateHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestRotateClientSecretConflict(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	client := newClient()
+	assert.NoError(t, initTestStorage().CreateClient(client))
+
+	racing := redis.NewClient(&redis.Options{Addr: pool.Options().Addr})
+	defer racing.Close()
+	racing.AddHook(&racingUpdateHook{storage: initTestStorage(), client: client})
+	storage := New(racing, "test123")
+
+	err := storage.RotateClientSecret(ctx, client.GetId(), "new", time.Hour)
+	assert.ErrorIs(t, err, ErrVersionConflict)
+
+	// The rotation that lost left no trace in the metadata.
+	_, err = storage.GetClientMeta(ctx, client.GetId())
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, storage.VerifyClientSecret(ctx, client.GetId(), "secret"))
+	assert.ErrorIs(t, storage.VerifyClientSecret(ctx, client.GetId(), "new"), ErrSecretMismatch)
+}
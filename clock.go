@@ -0,0 +1,12 @@
+package osinredis
+
+import "time"
+
+// Clock tells the storage the current time.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
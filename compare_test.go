@@ -0,0 +1,15 @@
+package osinredis
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecureCompare(t *testing.T) {
+	assert.True(t, SecureCompare("secret", "secret"))
+	assert.True(t, SecureCompare("", ""))
+	assert.False(t, SecureCompare("secret", "secreT"))
+	assert.False(t, SecureCompare("secret", "secret2"))
+	assert.False(t, SecureCompare("", "secret"))
+}
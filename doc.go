@@ -3,30 +3,49 @@ A Redis storage backend for osin.
 
 Installation:
 
-	go get github.com/ShaleApps/osinredis
+	go get github.com/cdyue/osinredis
 
 Usage:
 
 	import (
 		"github.com/RangelReale/osin"
-		"github.com/ShaleApps/osinredis"
-		"github.com/garyburd/redigo/redis"
+		"github.com/cdyue/osinredis"
+		"github.com/redis/go-redis/v9"
 	)
 
 	func main() {
-		pool = &redis.Pool{
-			Dial: func() (redis.Conn, error) {
-				conn, err := redis.Dial("tcp", ":6379")
-				if err != nil {
-					return nil, err
-				}
-				return conn, nil
-			},
-		}
+		pool := redis.NewClient(&redis.Options{
+			Addr: ":6379",
+		})
 
 		storage := osinredis.New(pool, "prefix")
 		server := osin.NewServer(osin.NewServerConfig(), storage)
 	}
 
+The storage is built on go-redis v9 (github.com/redis/go-redis/v9) and takes
+a redis.UniversalClient configured by the caller, typically a *redis.Client.
+A *redis.ClusterClient requires WithClusterHashTags.
+
+Lookups of a client, authorization code or token that does not exist return an
+error wrapping ErrNotFound, so callers can tell a missing entry from Redis being
+unavailable:
+
+	client, err := storage.GetClient(id)
+	if errors.Is(err, osinredis.ErrNotFound) {
+		// no such client
+	}
+
+Every error is an *OpError naming the method and, where there is one, the key
+that failed.
+
+The methods iterating the keyspace with SCAN, such as ListClients, Reap and
+Export, stop with ctx's error once ctx is done, issuing no further SCAN. The
+SCAN in flight is issued on ctx as well, but go-redis only interrupts a
+command waiting on the server at ctx's deadline, and only for a client
+created with ContextTimeoutEnabled.
+
+An authorization code removed with RemoveAuthorize is reported by
+LoadAuthorize with ErrRevoked, which also matches ErrNotFound, until it would
+have expired.
 */
 package osinredis
@@ -0,0 +1,189 @@
+package osinredis
+
+import (
+	"context"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// ReapDryRun performs the lookups of Reap and returns the keys it would
+// delete, without deleting anything. Since nothing is locked, a later Reap
+// may delete more or fewer keys if tokens are written in the meantime.
+func (s *Storage) ReapDryRun(ctx context.Context) (_ []string, err error) {
+	ctx, done := s.begin(ctx, "ReapDryRun")
+	defer done(&err)
+
+	var plan []string
+	for _, namespace := range []string{"access_token", "refresh_token"} {
+		keys, err := s.planReapPointers(ctx, namespace)
+		if err != nil {
+			return nil, err
+		}
+		plan = append(plan, keys...)
+	}
+	sk := &skipper{policy: s.scanErrorPolicy}
+	keys, err := s.planReapRecords(ctx, sk)
+	if err != nil {
+		return nil, err
+	}
+	return append(plan, keys...), sk.err()
+}
+
+// RevokeAllForClientDryRun performs the lookups of RevokeAllForClient and
+// returns the keys it would delete, without deleting anything. The index sets
+// tokens are only removed from are not listed, except that of the client.
+func (s *Storage) RevokeAllForClientDryRun(ctx context.Context, clientID string) (_ []string, err error) {
+	ctx, done := s.begin(ctx, "RevokeAllForClientDryRun")
+	defer done(&err)
+
+	setKey := s.makeKey("client_tokens", clientID)
+	accessIDs, err := s.pool.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get client tokens")
+	}
+
+	var plan []string
+	for _, accessID := range accessIDs {
+		access, err := s.getAccess(ctx, accessID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to load access for revocation")
+		}
+		refreshIDs, err := s.pool.SMembers(ctx, s.makeKey("access_refresh", accessID)).Result()
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get refresh tokens of access")
+		}
+		keys, sets := s.removeAccessKeys(s.makeKey("access", accessID), accessID, access, refreshIDs)
+		plan = append(plan, keys[1+sets:]...)
+	}
+	plan = append(plan, setKey)
+	return s.existingKeys(ctx, plan)
+}
+
+func (s *Storage) planReapPointers(ctx context.Context, namespace string) ([]string, error) {
+	var plan []string
+	err := s.scanKeys(ctx, namespace, func(keys []string) error {
+		values, err := s.pool.MGet(ctx, keys...).Result()
+		if err != nil {
+			return errors.Wrapf(err, "unable to MGET %s", namespace)
+		}
+		for i, value := range values {
+			accessID, ok := value.(string)
+			if !ok {
+				continue
+			}
+			n, err := s.pool.Exists(ctx, s.makeKey("access", accessID)).Result()
+			if err != nil {
+				return errors.Wrapf(err, "unable to check %s", namespace)
+			}
+			if n == 0 {
+				plan = append(plan, keys[i])
+			}
+		}
+		return nil
+	})
+	return plan, err
+}
+
+func (s *Storage) planReapRecords(ctx context.Context, sk *skipper) ([]string, error) {
+	var plan []string
+	err := s.scanKeys(ctx, "access", func(keys []string) error {
+		for _, key := range keys {
+			accessID, ok := s.keyID("access", key)
+			if !ok {
+				continue
+			}
+			access, err := s.scannedAccess(ctx, accessID, sk)
+			if err != nil {
+				return err
+			}
+			if access == nil {
+				continue
+			}
+			live, err := s.hasPointer(ctx, accessID, access)
+			if err != nil {
+				return err
+			}
+			if live {
+				continue
+			}
+			records, err := s.existingKeys(ctx, []string{key, s.makeKey("access_family", accessID), s.makeKey("access_meta", accessID),
+				s.makeKey("access_refresh", accessID), s.makeKey("access_tags", accessID)})
+			if err != nil {
+				return err
+			}
+			plan = append(plan, records...)
+		}
+		return nil
+	})
+	return plan, err
+}
+
+// hasPointer reports whether the access or refresh token pointer of access,
+// or that of a refresh token in its access_refresh set, still resolves to
+// accessID, or under WithStatelessAccess whether the record has not reached
+// its expiry, either of which keeps Reap from deleting the record.
+func (s *Storage) hasPointer(ctx context.Context, accessID string, access *osin.AccessData) (bool, error) {
+	if s.statelessAccess {
+		ttl, err := s.pool.PTTL(ctx, s.makeKey("access", accessID)).Result()
+		if err != nil {
+			return false, errors.Wrap(err, "unable to get access TTL")
+		}
+		if ttl > 0 {
+			return true, nil
+		}
+	}
+	refreshIDs, err := s.pool.SMembers(ctx, s.makeKey("access_refresh", accessID)).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to get refresh tokens of access")
+	}
+	keys := append([]string{s.tokenKey("access_token", access.AccessToken)}, s.refreshPointerKeys(refreshIDs)...)
+	if access.RefreshToken != "" {
+		keys = append(keys, s.tokenKey("refresh_token", access.RefreshToken))
+	}
+	values, err := s.pool.MGet(ctx, keys...).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to get token pointers")
+	}
+	for _, value := range values {
+		if pointer, ok := value.(string); ok && pointer == accessID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// existingKeys returns those of keys that exist, once each.
+func (s *Storage) existingKeys(ctx context.Context, keys []string) ([]string, error) {
+	seen := make(map[string]bool, len(keys))
+	pipe := s.pool.Pipeline()
+	var (
+		unique []string
+		exists []*redis.IntCmd
+	)
+	for _, key := range keys {
+		if !seen[key] {
+			seen[key] = true
+			unique = append(unique, key)
+			exists = append(exists, pipe.Exists(ctx, key))
+		}
+	}
+	if len(unique) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.Wrap(err, "unable to check keys")
+	}
+
+	var existing []string
+	for i, key := range unique {
+		if exists[i].Val() > 0 {
+			existing = append(existing, key)
+		}
+	}
+	return existing, nil
+}
@@ -0,0 +1,57 @@
+package osinredis
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ErrInvalidKey is returned by every operation of a Storage configured with
+// WithEncryption and a key that is not 32 bytes long.
+var ErrInvalidKey = errors.New("osinredis: encryption key must be 32 bytes")
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	if len(key) != 32 {
+		return nil, errors.Wrapf(ErrInvalidKey, "got %d bytes", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to create cipher")
+	}
+	aead, err := cipher.NewGCM(block)
+	return aead, errors.Wrap(err, "unable to create cipher")
+}
+
+func (s *Storage) encrypt(data []byte) ([]byte, error) {
+	if s.aeadErr != nil {
+		return nil, s.aeadErr
+	}
+
+	header := []byte{headerAESGCM}
+	out := make([]byte, 1+s.aead.NonceSize(), 1+s.aead.NonceSize()+len(data)+s.aead.Overhead())
+	out[0] = headerAESGCM
+	nonce := out[1:]
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, errors.Wrap(err, "unable to generate nonce")
+	}
+	return s.aead.Seal(out, nonce, data, header), nil
+}
+
+func (s *Storage) decrypt(data []byte) ([]byte, error) {
+	if s.aeadErr != nil {
+		return nil, s.aeadErr
+	}
+	if s.aead == nil {
+		return nil, errors.New("unable to decrypt: no encryption key configured")
+	}
+	if len(data) < s.aead.NonceSize() {
+		return nil, errors.New("unable to decrypt: payload too short")
+	}
+
+	nonce, ciphertext := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, ciphertext, []byte{headerAESGCM})
+	return plaintext, errors.Wrap(err, "unable to decrypt")
+}
@@ -0,0 +1,55 @@
+package osinredis
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var testKey = bytes.Repeat([]byte("k"), 32)
+
+func TestWithEncryption(t *testing.T) {
+	flushAll()
+
+	plain := initTestStorage()
+	encrypted := New(pool, "test123", WithEncryption(testKey), WithCompression())
+
+	client := newClient()
+	assert.NoError(t, encrypted.CreateClient(client))
+
+	legacy := newAccessData(newAuthorizeData(client))
+	legacy.AccessToken = "legacy"
+	legacy.RefreshToken = "rlegacy"
+	assert.NoError(t, plain.SaveAccess(legacy))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.UserData = map[string]interface{}{"secret": "sensitive-user-data"}
+	assert.NoError(t, encrypted.SaveAccess(accessData))
+
+	raw := getRawAccess(t, encrypted, accessData.AccessToken)
+	assert.Equal(t, headerAESGCM, raw[0])
+	assert.False(t, bytes.Contains(raw, []byte("sensitive-user-data")))
+
+	loadData, err := encrypted.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.UserData, loadData.UserData)
+
+	loadData, err = encrypted.LoadAccess(legacy.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, legacy.AccessToken, loadData.AccessToken)
+
+	_, err = plain.LoadAccess(accessData.AccessToken)
+	assert.Error(t, err)
+
+	wrongKey := New(pool, "test123", WithEncryption(bytes.Repeat([]byte("w"), 32)))
+	_, err = wrongKey.LoadAccess(accessData.AccessToken)
+	assert.Error(t, err)
+}
+
+func TestWithEncryptionInvalidKey(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithEncryption([]byte("short")))
+	assert.ErrorIs(t, storage.CreateClient(newClient()), ErrInvalidKey)
+}
@@ -0,0 +1,92 @@
+package osinredis
+
+import "github.com/pkg/errors"
+
+// ErrNotFound is returned (wrapped) by the lookup methods when the requested
+// client, authorization code or token does not exist in Redis. Test for it with
+// errors.Is(err, osinredis.ErrNotFound); any other error is a transport or
+// decoding failure.
+var ErrNotFound = errors.New("osinredis: not found")
+
+// ErrRevoked is returned (wrapped) by LoadAuthorize for an authorization code
+// that was removed with RemoveAuthorize rather than left to expire, which may
+// indicate a replayed code. It also matches ErrNotFound.
+var ErrRevoked error = revokedError{}
+
+type revokedError struct{}
+
+func (revokedError) Error() string { return "osinredis: revoked" }
+
+func (revokedError) Is(target error) bool { return target == ErrNotFound }
+
+// ErrPayloadTooLarge is returned (wrapped) when a client, authorization code
+// or access token serializes to more bytes than allowed by WithMaxPayloadSize.
+var ErrPayloadTooLarge = errors.New("osinredis: payload too large")
+
+// ErrTokenCollision is returned (wrapped) by SaveAccess and RotateRefresh
+// under WithSetNX when a token pointer they would write already exists.
+var ErrTokenCollision = errors.New("osinredis: token collision")
+
+// ErrInvalidClientID is returned (wrapped) when a client with an empty ID is
+// stored.
+var ErrInvalidClientID = errors.New("osinredis: empty client ID")
+
+// ErrInvalidToken is returned (wrapped) when authorize data with an empty
+// code, or access data with an empty access token, is saved.
+var ErrInvalidToken = errors.New("osinredis: empty code or token")
+
+// ErrInvalidExpiry is returned (wrapped) by SaveAuthorize for authorize data
+// whose ExpiresIn is not positive, unless WithMinAuthorizeTTL is in use.
+var ErrInvalidExpiry = errors.New("osinredis: non-positive expiry")
+
+// ErrSecretMismatch is returned (wrapped) by VerifyClientSecret for a secret
+// that is neither the client's secret nor a former one still accepted.
+var ErrSecretMismatch = errors.New("osinredis: client secret does not match")
+
+// ErrVersionConflict is returned (wrapped) by UpdateClientCAS when the client
+// was changed since the expected version was read.
+var ErrVersionConflict = errors.New("osinredis: version conflict")
+
+// ErrStateless is returned (wrapped) by the methods looking up access tokens
+// under WithStatelessAccess, since access tokens are then not stored; the
+// caller verifies them itself.
+var ErrStateless = errors.New("osinredis: access tokens are stateless")
+
+// OpError is the error returned by the storage methods. It records the
+// method that failed and, when the failure concerns a single key, that key.
+// Use errors.As to inspect it; errors.Is sees through it to ErrNotFound and
+// the other errors of this package.
+//
+// The key is left out of the message, since it may contain a token.
+type OpError struct {
+	Op  string // the method name, such as "LoadAccess"
+	Key string // the Redis key involved, or ""
+	Err error
+}
+
+func (e *OpError) Error() string { return "osinredis: " + e.Op + ": " + e.Err.Error() }
+
+func (e *OpError) Unwrap() error { return e.Err }
+
+// keyError attaches key to err. The method name is filled in by begin.
+func keyError(key string, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &OpError{Key: key, Err: err}
+}
+
+// opError returns err as the error of the method op.
+func opError(op string, err error) error {
+	if err == nil {
+		return nil
+	}
+	var opErr *OpError
+	if errors.As(err, &opErr) {
+		if opErr.Op == "" {
+			opErr.Op = op
+		}
+		return err
+	}
+	return &OpError{Op: op, Err: err}
+}
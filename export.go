@@ -0,0 +1,196 @@
+package osinredis
+
+import (
+	"context"
+	"encoding/gob"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// exportVersion is the version of the stream written by Export.
+const exportVersion = 1
+
+// exportHeader starts an export stream.
+type exportHeader struct {
+	Version int
+}
+
+// exportRecord holds one key of an export stream. Keys are identified by
+// namespace and id rather than by their full name, so that an export can be
+// imported under another prefix or key layout.
+type exportRecord struct {
+	Namespace string
+	ID        string
+	TTL       time.Duration     // zero if the key does not expire
+	Value     []byte            // the value of a string key
+	Members   []string          // the members of a set key
+	Hash      map[string]string // the fields of a hash key
+	IsSet     bool
+}
+
+// Export writes every client, authorization code and token of the storage to
+// w, with their remaining TTLs, for Import to restore. The keys are read one
+// page at a time, so the export is a point-in-time snapshot of each key but
+// not a transactional one: writes made while it runs may or may not be
+// included.
+func (s *Storage) Export(ctx context.Context, w io.Writer) (err error) {
+	ctx, done := s.begin(ctx, "Export")
+	defer done(&err)
+
+	enc := gob.NewEncoder(w)
+	if err := enc.Encode(exportHeader{Version: exportVersion}); err != nil {
+		return errors.Wrap(err, "unable to write export")
+	}
+
+	for _, namespace := range namespaceIDs {
+		err := s.scanKeys(ctx, namespace, func(keys []string) error {
+			records, err := s.exportKeys(ctx, namespace, keys)
+			if err != nil {
+				return err
+			}
+			for _, record := range records {
+				if err := enc.Encode(record); err != nil {
+					return errors.Wrap(err, "unable to write export")
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (s *Storage) exportKeys(ctx context.Context, namespace string, keys []string) ([]*exportRecord, error) {
+	pipe := s.pool.Pipeline()
+	types := make([]*redis.StatusCmd, len(keys))
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		types[i] = pipe.Type(ctx, key)
+		ttls[i] = pipe.PTTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.Wrapf(err, "unable to export %s", namespace)
+	}
+
+	var (
+		records []*exportRecord
+		values  []*redis.StringCmd
+		members []*redis.StringSliceCmd
+		hashes  []*redis.MapStringStringCmd
+	)
+	for i, key := range keys {
+		id, ok := s.keyID(namespace, key)
+		if !ok {
+			continue
+		}
+		record := &exportRecord{Namespace: namespace, ID: id}
+		if ttl := ttls[i].Val(); ttl > 0 {
+			record.TTL = ttl
+		}
+		switch types[i].Val() {
+		case "string":
+			values = append(values, pipe.Get(ctx, key))
+			members = append(members, nil)
+			hashes = append(hashes, nil)
+		case "set":
+			record.IsSet = true
+			values = append(values, nil)
+			members = append(members, pipe.SMembers(ctx, key))
+			hashes = append(hashes, nil)
+		case "hash":
+			values = append(values, nil)
+			members = append(members, nil)
+			hashes = append(hashes, pipe.HGetAll(ctx, key))
+		default:
+			// The key expired since the SCAN.
+			continue
+		}
+		records = append(records, record)
+	}
+	if len(records) == 0 {
+		return nil, nil
+	}
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, errors.Wrapf(err, "unable to export %s", namespace)
+	}
+
+	exported := records[:0]
+	for i, record := range records {
+		switch {
+		case record.IsSet:
+			record.Members = members[i].Val()
+			if len(record.Members) == 0 {
+				continue
+			}
+		case hashes[i] != nil:
+			record.Hash = hashes[i].Val()
+			if len(record.Hash) == 0 {
+				continue
+			}
+		default:
+			value, err := values[i].Bytes()
+			if err != nil {
+				continue
+			}
+			record.Value = value
+		}
+		exported = append(exported, record)
+	}
+	return exported, nil
+}
+
+// Import restores the keys written by Export, with their remaining TTLs as of
+// the export. Existing keys with the same names are overwritten.
+func (s *Storage) Import(ctx context.Context, r io.Reader) (err error) {
+	ctx, done := s.begin(ctx, "Import")
+	defer done(&err)
+
+	dec := gob.NewDecoder(r)
+	var header exportHeader
+	if err := dec.Decode(&header); err != nil {
+		return errors.Wrap(err, "unable to read export")
+	}
+	if header.Version != exportVersion {
+		return errors.Errorf("osinredis: unsupported export version %d", header.Version)
+	}
+
+	for {
+		var record exportRecord
+		if err := dec.Decode(&record); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "unable to read export")
+		}
+
+		key := s.makeKey(record.Namespace, record.ID)
+		pipe := s.pool.TxPipeline()
+		switch {
+		case record.IsSet:
+			pipe.Del(ctx, key)
+			members := make([]interface{}, len(record.Members))
+			for i, member := range record.Members {
+				members[i] = member
+			}
+			pipe.SAdd(ctx, key, members...)
+			if record.TTL > 0 {
+				pipe.PExpire(ctx, key, record.TTL)
+			}
+		case len(record.Hash) > 0:
+			pipe.Del(ctx, key)
+			pipe.HSet(ctx, key, record.Hash)
+			if record.TTL > 0 {
+				pipe.PExpire(ctx, key, record.TTL)
+			}
+		default:
+			pipe.Set(ctx, key, record.Value, record.TTL)
+		}
+		if _, err := pipe.Exec(ctx); err != nil {
+			return errors.Wrapf(err, "unable to import %s", record.Namespace)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package osinredis
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExportImport(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	source := New(pool, "test123", WithRefreshTTL(24*time.Hour))
+	client := newClient()
+	assert.NoError(t, source.CreateClient(client))
+	assert.NoError(t, source.SetClientMeta(ctx, client.GetId(), &ClientMeta{DisplayName: "Dashboard"}))
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, source.SaveAuthorize(authorizeData))
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, source.SaveAccess(accessData))
+
+	var buf bytes.Buffer
+	assert.NoError(t, source.Export(ctx, &buf))
+
+	target := New(pool, "restored", WithRefreshTTL(24*time.Hour))
+	assert.NoError(t, target.Import(ctx, bytes.NewReader(buf.Bytes())))
+
+	clientFound, err := target.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+	meta, err := target.GetClientMeta(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, "Dashboard", meta.DisplayName)
+
+	authFound, err := target.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, authorizeData, authFound)
+
+	accessFound, err := target.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.RefreshToken, accessFound.RefreshToken)
+	ttl, err := target.TokenTTL(ctx, accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 1)
+	ttl, err = target.RefreshTTL(ctx, accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.InDelta(t, (24 * time.Hour).Seconds(), ttl.Seconds(), 1)
+
+	familyID, err := target.RefreshFamily(ctx, accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, familyID)
+
+	revoked, err := target.RevokeAllForClient(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+
+	_, err = source.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+}
+
+func TestExportImportTags(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	source := New(pool, "test123", WithTagIndex())
+	client := newClient()
+	assert.NoError(t, source.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	tags := map[string]string{"mfa": "true", "ip": "1.2.3.4"}
+	accessID, err := source.SaveAccessWithTags(ctx, accessData, tags)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	assert.NoError(t, source.Export(ctx, &buf))
+	flushAll()
+	assert.NoError(t, source.Import(ctx, bytes.NewReader(buf.Bytes())))
+
+	key := source.makeKey("access_tags", accessID)
+	assert.Equal(t, tags, pool.HGetAll(ctx, key).Val())
+	assert.InDelta(t, time.Hour.Seconds(), pool.TTL(ctx, key).Val().Seconds(), 1)
+	tokens, err := source.FindTokensByTag(ctx, "mfa", "true")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{accessData.AccessToken}, tokens)
+
+	// Importing over an existing hash replaces its fields.
+	assert.NoError(t, pool.HSet(ctx, key, "stale", "1").Err())
+	assert.NoError(t, source.Import(ctx, bytes.NewReader(buf.Bytes())))
+	assert.Equal(t, tags, pool.HGetAll(ctx, key).Val())
+}
+
+func TestImportRejectsUnknownVersion(t *testing.T) {
+	var buf bytes.Buffer
+	assert.NoError(t, gob.NewEncoder(&buf).Encode(exportHeader{Version: exportVersion + 1}))
+	assert.Error(t, initTestStorage().Import(context.Background(), &buf))
+}
@@ -6,7 +6,6 @@ require (
 	github.com/RangelReale/osin v1.0.1
 	github.com/pkg/errors v0.9.1
 	github.com/redis/go-redis/v9 v9.6.1
-	github.com/satori/go.uuid v1.2.0
 	github.com/stretchr/testify v1.9.0
 )
 
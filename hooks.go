@@ -0,0 +1,55 @@
+package osinredis
+
+import (
+	"context"
+
+	"github.com/RangelReale/osin"
+)
+
+// Hooks are callbacks notified of the token lifecycle, e.g. to feed an audit
+// log. Each is called synchronously, after the Redis operation it reports has
+// succeeded, and is never called for one that failed; a callback with slow
+// work to do should hand it to a goroutine. Under WithRevokeConcurrency,
+// OnRemoveAccess may be called from several goroutines at once. Nil callbacks
+// are skipped.
+type Hooks struct {
+	// OnSaveAccess is called when access data is saved by SaveAccess or
+	// RotateRefresh, with the ID of its access record.
+	OnSaveAccess func(ctx context.Context, accessID string, data *osin.AccessData)
+
+	// OnRemoveAccess is called when an access record is removed by
+	// RemoveAccess, RemoveRefresh or a revocation method. data is nil if the
+	// record had already expired and only its pointers were left.
+	OnRemoveAccess func(ctx context.Context, accessID string, data *osin.AccessData)
+
+	// OnSaveAuthorize is called when an authorization code is saved.
+	OnSaveAuthorize func(ctx context.Context, data *osin.AuthorizeData)
+
+	// OnRemoveAuthorize is called when an authorization code is removed by
+	// RemoveAuthorize or ConsumeAuthorize.
+	OnRemoveAuthorize func(ctx context.Context, code string)
+}
+
+func (h *Hooks) saveAccess(ctx context.Context, accessID string, data *osin.AccessData) {
+	if h.OnSaveAccess != nil {
+		h.OnSaveAccess(ctx, accessID, data)
+	}
+}
+
+func (h *Hooks) removeAccess(ctx context.Context, accessID string, data *osin.AccessData) {
+	if h.OnRemoveAccess != nil {
+		h.OnRemoveAccess(ctx, accessID, data)
+	}
+}
+
+func (h *Hooks) saveAuthorize(ctx context.Context, data *osin.AuthorizeData) {
+	if h.OnSaveAuthorize != nil {
+		h.OnSaveAuthorize(ctx, data)
+	}
+}
+
+func (h *Hooks) removeAuthorize(ctx context.Context, code string) {
+	if h.OnRemoveAuthorize != nil {
+		h.OnRemoveAuthorize(ctx, code)
+	}
+}
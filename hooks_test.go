@@ -0,0 +1,59 @@
+package osinredis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWithHooks(t *testing.T) {
+	flushAll()
+
+	var events []string
+	storage := New(pool, "test123", WithHooks(Hooks{
+		OnSaveAccess: func(ctx context.Context, accessID string, data *osin.AccessData) {
+			events = append(events, "save access "+data.AccessToken)
+		},
+		OnRemoveAccess: func(ctx context.Context, accessID string, data *osin.AccessData) {
+			events = append(events, "remove access "+data.AccessToken)
+		},
+		OnSaveAuthorize: func(ctx context.Context, data *osin.AuthorizeData) {
+			events = append(events, "save authorize "+data.Code)
+		},
+		OnRemoveAuthorize: func(ctx context.Context, code string) {
+			events = append(events, "remove authorize "+code)
+		},
+	}))
+
+	ctx := context.Background()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	_, err := storage.ConsumeAuthorize(ctx, authorizeData.Code)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+
+	// Failed operations are not reported.
+	_, err = storage.ConsumeAuthorize(ctx, authorizeData.Code)
+	assert.Error(t, err)
+	storage.RemoveAccess(accessData.AccessToken)
+
+	assert.Equal(t, []string{
+		"save authorize 8888",
+		"remove authorize 8888",
+		"save access 8888",
+		"remove access 8888",
+	}, events)
+
+	// Hooks are optional.
+	storage = initTestStorage()
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+}
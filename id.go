@@ -0,0 +1,39 @@
+package osinredis
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/pkg/errors"
+)
+
+// IDGenerator generates the internal IDs of access records. IDs must be unique
+// across every storage sharing a key prefix.
+type IDGenerator interface {
+	NewID() (string, error)
+}
+
+// uuidGenerator generates random (version 4) UUIDs from crypto/rand, in the
+// same 36-character form as earlier releases.
+type uuidGenerator struct{}
+
+func (uuidGenerator) NewID() (string, error) {
+	var u [16]byte
+	if _, err := rand.Read(u[:]); err != nil {
+		return "", errors.Wrap(err, "unable to read random bytes")
+	}
+	u[6] = u[6]&0x0f | 0x40
+	u[8] = u[8]&0x3f | 0x80
+
+	var buf [36]byte
+	hex.Encode(buf[0:8], u[0:4])
+	buf[8] = '-'
+	hex.Encode(buf[9:13], u[4:6])
+	buf[13] = '-'
+	hex.Encode(buf[14:18], u[6:8])
+	buf[18] = '-'
+	hex.Encode(buf[19:23], u[8:10])
+	buf[23] = '-'
+	hex.Encode(buf[24:], u[10:])
+	return string(buf[:]), nil
+}
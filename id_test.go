@@ -0,0 +1,21 @@
+package osinredis
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var uuidV4 = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestUUIDGenerator(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		id, err := uuidGenerator{}.NewID()
+		assert.NoError(t, err)
+		assert.Regexp(t, uuidV4, id)
+		assert.False(t, seen[id])
+		seen[id] = true
+	}
+}
@@ -0,0 +1,91 @@
+package osinredis
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// IntrospectionResult is the response of an RFC 7662 token introspection.
+// Only Active is set for tokens that are unknown or have expired.
+type IntrospectionResult struct {
+	Active   bool   `json:"active"`
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+	Exp      int64  `json:"exp,omitempty"`
+	Iat      int64  `json:"iat,omitempty"`
+	Username string `json:"username,omitempty"`
+	Iss      string `json:"iss,omitempty"`
+}
+
+// accessMeta is the metadata stored, as JSON, alongside the access records
+// saved under WithIssuer.
+type accessMeta struct {
+	Issuer string `json:"iss"`
+}
+
+// Introspect describes the access token as an RFC 7662 introspection
+// response. Unknown and expired tokens are reported as inactive rather than
+// as an error. Username is filled in by the function set with
+// WithUsernameExtractor, and Iss from the issuer set with WithIssuer when the
+// token was saved.
+func (s *Storage) Introspect(ctx context.Context, token string) (_ *IntrospectionResult, err error) {
+	ctx, done := s.begin(ctx, "Introspect")
+	defer done(&err)
+	if s.statelessAccess {
+		return nil, ErrStateless
+	}
+
+	var (
+		access   *osin.AccessData
+		accessID string
+		ttl      time.Duration
+	)
+	err = s.retry(ctx, func() (err error) {
+		access, accessID, ttl, err = s.loadAccessWithTTL(ctx, "access_token", s.tokenKey("access_token", token))
+		return err
+	})
+	if errors.Is(err, ErrNotFound) {
+		return &IntrospectionResult{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	// As in LoadAccess, a token without expiry keeps the stored value.
+	if ttl >= 0 {
+		access.ExpiresIn = int32(ttl.Seconds())
+	}
+	if access.ExpiresIn <= 0 {
+		return &IntrospectionResult{}, nil
+	}
+
+	result := &IntrospectionResult{
+		Active: true,
+		Scope:  access.Scope,
+		Exp:    s.clock.Now().Add(time.Duration(access.ExpiresIn) * time.Second).Unix(),
+		Iat:    access.CreatedAt.Unix(),
+	}
+	if access.Client != nil {
+		result.ClientID = access.Client.GetId()
+	}
+	if s.usernameExtractor != nil {
+		result.Username = s.usernameExtractor(access)
+	}
+
+	raw, err := s.pool.Get(ctx, s.makeKey("access_meta", accessID)).Bytes()
+	if err != nil && !errors.Is(err, redis.Nil) {
+		return nil, errors.Wrap(err, "unable to get access meta")
+	}
+	if err == nil {
+		var meta accessMeta
+		if err := json.Unmarshal(raw, &meta); err != nil {
+			return nil, errors.Wrap(err, "failed to decode access meta")
+		}
+		result.Iss = meta.Issuer
+	}
+	return result, nil
+}
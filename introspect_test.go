@@ -0,0 +1,80 @@
+package osinredis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIntrospect(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	now := time.Unix(1700000000, 0)
+	storage := New(pool, "test123",
+		WithClock(&stepClock{now: now}),
+		WithUsernameExtractor(func(data *osin.AccessData) string {
+			return data.UserData.(string)
+		}),
+	)
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.Scope = "read write"
+	accessData.UserData = "alice"
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	result, err := storage.Introspect(ctx, accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, &IntrospectionResult{
+		Active:   true,
+		ClientID: client.GetId(),
+		Scope:    "read write",
+		Exp:      now.Unix() + 3600,
+		Iat:      accessData.CreatedAt.Unix(),
+		Username: "alice",
+	}, result)
+
+	result, err = storage.Introspect(ctx, "unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, &IntrospectionResult{}, result)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	result, err = storage.Introspect(ctx, accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.False(t, result.Active)
+}
+
+func TestWithIssuer(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithIssuer("https://auth.example.com"))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.CreatedAt = time.Unix(1700000000, 0)
+	accessID, err := storage.SaveAccessID(ctx, accessData)
+	assert.NoError(t, err)
+
+	result, err := storage.Introspect(ctx, accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://auth.example.com", result.Iss)
+	assert.Equal(t, int64(1700000000), result.Iat)
+
+	loaded, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, accessData.CreatedAt.Equal(loaded.CreatedAt))
+
+	// The issuer is the one recorded at save time.
+	result, err = initTestStorage().Introspect(ctx, accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "https://auth.example.com", result.Iss)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("access_meta", accessID)).Val())
+}
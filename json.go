@@ -0,0 +1,130 @@
+package osinredis
+
+import (
+	"encoding/json"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+)
+
+// JSONSerializer is a Serializer that stores values as JSON, so that tools
+// written in other languages can inspect them directly in Redis. Stored
+// values start with a one-byte schema version header, which such tools must
+// skip.
+//
+// osin.AccessData and osin.AuthorizeData hold their client as the osin.Client
+// interface, so the serializer needs a concrete type to decode clients into.
+// It uses *osin.DefaultClient unless configured with WithJSONClientType.
+type JSONSerializer struct {
+	newClient func() osin.Client
+}
+
+// plainAccessData and plainAuthorizeData are embedded by the decoding
+// helpers, whose own fields shadow the interface-typed ones.
+type (
+	plainAccessData    osin.AccessData
+	plainAuthorizeData osin.AuthorizeData
+)
+
+// JSONOption configures a JSONSerializer.
+type JSONOption func(*JSONSerializer)
+
+// WithJSONClientType sets the constructor of the concrete osin.Client that
+// embedded clients are decoded into. It must return a pointer.
+func WithJSONClientType(newClient func() osin.Client) JSONOption {
+	return func(j *JSONSerializer) {
+		j.newClient = newClient
+	}
+}
+
+// NewJSONSerializer returns a JSONSerializer.
+func NewJSONSerializer(opts ...JSONOption) *JSONSerializer {
+	j := &JSONSerializer{
+		newClient: func() osin.Client { return &osin.DefaultClient{} },
+	}
+	for _, opt := range opts {
+		opt(j)
+	}
+	return j
+}
+
+// Marshal encodes v as JSON.
+func (j *JSONSerializer) Marshal(v interface{}) ([]byte, error) {
+	data, err := json.Marshal(v)
+	return data, errors.Wrap(err, "unable to encode")
+}
+
+// Unmarshal decodes the JSON in data into v, reconstructing the clients
+// embedded in osin.AccessData and osin.AuthorizeData.
+func (j *JSONSerializer) Unmarshal(data []byte, v interface{}) error {
+	var err error
+	switch v := v.(type) {
+	case *osin.AccessData:
+		err = j.unmarshalAccess(data, v)
+	case *osin.AuthorizeData:
+		err = j.unmarshalAuthorize(data, v)
+	default:
+		err = json.Unmarshal(data, v)
+	}
+	return errors.Wrap(err, "unable to decode")
+}
+
+func (j *JSONSerializer) unmarshalAccess(data []byte, access *osin.AccessData) error {
+	aux := struct {
+		*plainAccessData
+		Client        json.RawMessage
+		AuthorizeData json.RawMessage
+		AccessData    json.RawMessage
+	}{plainAccessData: (*plainAccessData)(access)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	if access.Client, err = j.unmarshalClient(aux.Client); err != nil {
+		return err
+	}
+
+	access.AuthorizeData = nil
+	if !isJSONNull(aux.AuthorizeData) {
+		access.AuthorizeData = &osin.AuthorizeData{}
+		if err := j.unmarshalAuthorize(aux.AuthorizeData, access.AuthorizeData); err != nil {
+			return err
+		}
+	}
+
+	access.AccessData = nil
+	if !isJSONNull(aux.AccessData) {
+		access.AccessData = &osin.AccessData{}
+		if err := j.unmarshalAccess(aux.AccessData, access.AccessData); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (j *JSONSerializer) unmarshalAuthorize(data []byte, auth *osin.AuthorizeData) error {
+	aux := struct {
+		*plainAuthorizeData
+		Client json.RawMessage
+	}{plainAuthorizeData: (*plainAuthorizeData)(auth)}
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	var err error
+	auth.Client, err = j.unmarshalClient(aux.Client)
+	return err
+}
+
+func (j *JSONSerializer) unmarshalClient(data json.RawMessage) (osin.Client, error) {
+	if isJSONNull(data) {
+		return nil, nil
+	}
+	client := j.newClient()
+	return client, json.Unmarshal(data, client)
+}
+
+func isJSONNull(data json.RawMessage) bool {
+	return len(data) == 0 || string(data) == "null"
+}
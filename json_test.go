@@ -0,0 +1,89 @@
+package osinredis
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func initJSONTestStorage() *Storage {
+	return New(pool, "test123", WithSerializer(NewJSONSerializer()))
+}
+
+func TestJSONSerializerClient(t *testing.T) {
+	flushAll()
+
+	storage := initJSONTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	raw, err := pool.Get(context.Background(), storage.makeKey("client", client.GetId())).Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, headerSchemaV1, raw[0])
+	assert.True(t, json.Valid(raw[1:]))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+}
+
+func TestJSONSerializerAuthorize(t *testing.T) {
+	flushAll()
+
+	storage := initJSONTestStorage()
+	client := newClient()
+	authorizeData := newAuthorizeData(client)
+	authorizeData.CreatedAt = authorizeData.CreatedAt.UTC()
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	loadData, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, authorizeData, loadData)
+}
+
+func TestJSONSerializerAccess(t *testing.T) {
+	flushAll()
+
+	storage := initJSONTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	authorizeData.CreatedAt = authorizeData.CreatedAt.UTC()
+	accessData := newAccessData(authorizeData)
+	accessData.CreatedAt = accessData.CreatedAt.UTC()
+	accessData.UserData = map[string]interface{}{"user": "alice"}
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	raw, err := pool.Get(context.Background(), storage.makeKey("access_token", accessData.AccessToken)).Result()
+	assert.NoError(t, err)
+	blob, err := pool.Get(context.Background(), storage.makeKey("access", raw)).Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, headerSchemaV1, blob[0])
+	assert.True(t, json.Valid(blob[1:]))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData, loadData)
+}
+
+type jsonTestClient struct {
+	osin.DefaultClient
+	Name string
+}
+
+func TestJSONSerializerClientType(t *testing.T) {
+	serializer := NewJSONSerializer(WithJSONClientType(func() osin.Client { return &jsonTestClient{} }))
+
+	client := &jsonTestClient{DefaultClient: osin.DefaultClient{Id: "clientID"}, Name: "dashboard"}
+	data, err := serializer.Marshal(&osin.AuthorizeData{Client: client, Code: "8888", ExpiresIn: 60, CreatedAt: time.Unix(0, 0).UTC()})
+	assert.NoError(t, err)
+
+	var auth osin.AuthorizeData
+	assert.NoError(t, serializer.Unmarshal(data, &auth))
+	assert.Equal(t, client, auth.Client)
+}
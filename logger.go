@@ -0,0 +1,10 @@
+package osinredis
+
+// Logger receives the errors encountered by storage operations.
+type Logger interface {
+	Errorf(format string, args ...interface{})
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Errorf(format string, args ...interface{}) {}
@@ -0,0 +1,42 @@
+package osinredis
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (r *recordingLogger) Errorf(format string, args ...interface{}) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lines = append(r.lines, fmt.Sprintf(format, args...))
+}
+
+func TestWithLogger(t *testing.T) {
+	flushAll()
+
+	logger := &recordingLogger{}
+	storage := New(pool, "test123", WithLogger(logger))
+
+	_, err := storage.GetClient("notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Empty(t, logger.lines)
+
+	unreachable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer unreachable.Close()
+
+	storage = New(unreachable, "test123", WithLogger(logger))
+	_, err = storage.LoadAccess("token")
+	assert.Error(t, err)
+	if assert.Len(t, logger.lines, 1) {
+		assert.Contains(t, logger.lines[0], "LoadAccess")
+	}
+}
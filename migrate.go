@@ -0,0 +1,65 @@
+package osinredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// defaultMigrateTTL is the TTL MigrateFixTTLs gives keys unless changed with
+// WithMigrateTTL.
+const defaultMigrateTTL = time.Hour
+
+// fixTTLScript sets the TTL of KEYS[1] to ARGV[2] milliseconds if it expires
+// within ARGV[1] milliseconds.
+var fixTTLScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 or ttl >= tonumber(ARGV[1]) then
+	return 0
+end
+return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+`)
+
+// MigrateFixTTLs repairs the access records and token pointers written by
+// releases that passed ExpiresIn to Redis as nanoseconds, which expire
+// within a second: it gives each of them the TTL set with WithMigrateTTL,
+// one hour by default, and returns how many it fixed. Like
+// CountAccessTokens, it iterates the whole keyspace with SCAN.
+//
+// Run it once, right after upgrading: it cannot tell a broken key from a
+// healthy one about to expire, which it extends as well.
+func (s *Storage) MigrateFixTTLs(ctx context.Context) (fixed int, err error) {
+	ctx, done := s.begin(ctx, "MigrateFixTTLs")
+	defer done(&err)
+
+	if err := fixTTLScript.Load(ctx, s.pool).Err(); err != nil {
+		return 0, errors.Wrap(err, "unable to load TTL script")
+	}
+	threshold := time.Second.Milliseconds()
+	target := s.migrateTTL.Milliseconds()
+
+	for _, namespace := range []string{"access", "access_family", "access_token", "refresh_token"} {
+		err := s.scanKeys(ctx, namespace, func(keys []string) error {
+			pipe := s.pool.Pipeline()
+			cmds := make([]*redis.Cmd, len(keys))
+			for i, key := range keys {
+				cmds[i] = fixTTLScript.EvalSha(ctx, pipe, []string{key}, threshold, target)
+			}
+			pipe.Exec(ctx)
+			for _, cmd := range cmds {
+				n, err := cmd.Int()
+				if err != nil {
+					return errors.Wrapf(err, "unable to fix %s TTL", namespace)
+				}
+				fixed += n
+			}
+			return nil
+		})
+		if err != nil {
+			return fixed, err
+		}
+	}
+	return fixed, nil
+}
@@ -0,0 +1,47 @@
+package osinredis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMigrateFixTTLs(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithMigrateTTL(10*time.Minute))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	accessID, err := storage.SaveAccessID(ctx, accessData)
+	assert.NoError(t, err)
+
+	// Break the TTLs the way the nanosecond bug did.
+	broken := []string{
+		storage.makeKey("access", accessID),
+		storage.makeKey("access_token", accessData.AccessToken),
+		storage.makeKey("refresh_token", accessData.RefreshToken),
+	}
+	for _, key := range broken {
+		assert.NoError(t, pool.PExpire(ctx, key, 900*time.Millisecond).Err())
+	}
+
+	fixed, err := storage.MigrateFixTTLs(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, len(broken), fixed)
+	for _, key := range broken {
+		ttl := pool.PTTL(ctx, key).Val()
+		assert.True(t, ttl > 9*time.Minute && ttl <= 10*time.Minute, "%s has TTL %v", key, ttl)
+	}
+
+	// Healthy keys are left alone.
+	ttl := pool.TTL(ctx, storage.makeKey("access_family", accessID)).Val()
+	assert.True(t, ttl > 10*time.Minute)
+
+	fixed, err = storage.MigrateFixTTLs(ctx)
+	assert.NoError(t, err)
+	assert.Zero(t, fixed)
+}
@@ -0,0 +1,108 @@
+package osinredis
+
+// Namespaces names the namespaces keys are grouped in, the part of each key
+// between the prefix and the id. Two storages sharing a Redis and a prefix
+// must use distinct names.
+type Namespaces struct {
+	Client          string // clients
+	ClientMeta      string // client metadata
+	ClientDisabled  string // suspended client flags
+	ClientTokens    string // access IDs issued to each client
+	ClientVersion   string // version counters of clients, for UpdateClientCAS
+	UserTokens      string // access IDs issued to each user, with WithUserExtractor
+	Authorize       string // authorize data by code
+	AuthRevoked     string // tombstones of removed authorization codes
+	Access          string // access data by access ID
+	AccessToken     string // access IDs by access token
+	RefreshToken    string // access IDs by refresh token
+	AccessFamily    string // refresh token family of each access ID
+	AccessMeta      string // metadata of each access ID, with WithIssuer
+	AccessRefresh   string // refresh tokens of each access ID
+	AccessTags      string // tags of each access ID, from SaveAccessWithTags
+	Family          string // access IDs of each refresh token family
+	RefreshConsumed string // families of rotated refresh tokens
+	Scope           string // access IDs granting each scope, with WithScopeIndex
+	Tag             string // access IDs carrying each tag, with WithTagIndex
+	RateLimit       string // issuance counters of AllowIssue
+}
+
+// DefaultNamespaces returns the namespaces used unless WithNamespaces is
+// given.
+func DefaultNamespaces() Namespaces {
+	return Namespaces{
+		Client:          "client",
+		ClientMeta:      "client_meta",
+		ClientDisabled:  "client_disabled",
+		ClientTokens:    "client_tokens",
+		ClientVersion:   "client_version",
+		UserTokens:      "user_tokens",
+		Authorize:       "auth",
+		AuthRevoked:     "auth_revoked",
+		Access:          "access",
+		AccessToken:     "access_token",
+		RefreshToken:    "refresh_token",
+		AccessFamily:    "access_family",
+		AccessMeta:      "access_meta",
+		AccessRefresh:   "access_refresh",
+		AccessTags:      "access_tags",
+		Family:          "family",
+		RefreshConsumed: "refresh_consumed",
+		Scope:           "scope",
+		Tag:             "tag",
+		RateLimit:       "ratelimit",
+	}
+}
+
+// namespaceIDs lists the default names of every namespace.
+var namespaceIDs = []string{
+	"client", "client_meta", "client_disabled", "client_tokens", "client_version", "user_tokens", "auth", "auth_revoked",
+	"access", "access_token", "refresh_token", "access_family", "access_meta", "access_refresh", "access_tags", "family",
+	"refresh_consumed", "scope", "tag", "ratelimit",
+}
+
+// name returns the configured name of a namespace given by its default name.
+func (n *Namespaces) name(namespace string) string {
+	switch namespace {
+	case "client":
+		return n.Client
+	case "client_meta":
+		return n.ClientMeta
+	case "client_disabled":
+		return n.ClientDisabled
+	case "client_tokens":
+		return n.ClientTokens
+	case "client_version":
+		return n.ClientVersion
+	case "user_tokens":
+		return n.UserTokens
+	case "auth":
+		return n.Authorize
+	case "auth_revoked":
+		return n.AuthRevoked
+	case "access":
+		return n.Access
+	case "access_token":
+		return n.AccessToken
+	case "refresh_token":
+		return n.RefreshToken
+	case "access_family":
+		return n.AccessFamily
+	case "access_meta":
+		return n.AccessMeta
+	case "access_refresh":
+		return n.AccessRefresh
+	case "access_tags":
+		return n.AccessTags
+	case "family":
+		return n.Family
+	case "refresh_consumed":
+		return n.RefreshConsumed
+	case "scope":
+		return n.Scope
+	case "tag":
+		return n.Tag
+	case "ratelimit":
+		return n.RateLimit
+	}
+	return namespace
+}
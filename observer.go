@@ -0,0 +1,34 @@
+package osinredis
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Observer receives the outcome of every storage operation, e.g. to feed
+// latency histograms and hit-rate dashboards.
+//
+// op is the method name, such as "LoadAccess". When the operation failed
+// because the requested key does not exist, op carries a ".miss" suffix
+// ("LoadAccess.miss") and err wraps ErrNotFound.
+type Observer interface {
+	ObserveOp(op string, duration time.Duration, err error)
+}
+
+// observe reports an operation started at start to the configured Observer,
+// and logs it if it failed.
+func (s *Storage) observe(op string, start time.Time, err *error) {
+	miss := errors.Is(*err, ErrNotFound)
+	if *err != nil && !miss {
+		s.logger.Errorf("%v", *err)
+	}
+
+	if s.observer == nil {
+		return
+	}
+	if miss {
+		op += ".miss"
+	}
+	s.observer.ObserveOp(op, s.clock.Now().Sub(start), *err)
+}
@@ -0,0 +1,131 @@
+package osinredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+type recordingObserver struct {
+	mu  sync.Mutex
+	ops []string
+}
+
+func (r *recordingObserver) ObserveOp(op string, duration time.Duration, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.ops = append(r.ops, op)
+}
+
+func TestWithObserver(t *testing.T) {
+	flushAll()
+
+	observer := &recordingObserver{}
+	storage := New(pool, "test123", WithObserver(observer))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	_, err := storage.GetClient("notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+
+	assert.Equal(t, []string{"CreateClient", "GetClient.miss", "SaveAccess", "LoadAccess", "RemoveAccess"}, observer.ops)
+}
+
+// stepClock advances by step every time it is read.
+type stepClock struct {
+	now  time.Time
+	step time.Duration
+}
+
+func (c *stepClock) Now() time.Time {
+	c.now = c.now.Add(c.step)
+	return c.now
+}
+
+type durationObserver struct {
+	durations []time.Duration
+}
+
+func (d *durationObserver) ObserveOp(op string, duration time.Duration, err error) {
+	d.durations = append(d.durations, duration)
+}
+
+func TestWithClock(t *testing.T) {
+	flushAll()
+
+	observer := &durationObserver{}
+	storage := New(pool, "test123", WithObserver(observer), WithClock(&stepClock{step: time.Second}))
+
+	assert.NoError(t, storage.CreateClient(newClient()))
+	_, err := storage.GetClient("notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Equal(t, []time.Duration{time.Second, time.Second}, observer.durations)
+}
+
+type spanKey struct{}
+
+type recordingTracer struct {
+	spans []string
+}
+
+func (r *recordingTracer) StartOp(ctx context.Context, op, namespace string) (context.Context, func(err error)) {
+	return context.WithValue(ctx, spanKey{}, op), func(err error) {
+		r.spans = append(r.spans, fmt.Sprintf("%s %s %v", op, namespace, errors.Is(err, ErrNotFound)))
+	}
+}
+
+// spanHook records the span each Redis command runs in.
+type spanHook struct {
+	spans []interface{}
+}
+
+func (h *spanHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *spanHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.spans = append(h.spans, ctx.Value(spanKey{}))
+		return next(ctx, cmd)
+	}
+}
+
+func (h *spanHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestWithTracer(t *testing.T) {
+	flushAll()
+
+	hook := &spanHook{}
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	client.AddHook(hook)
+	defer client.Close()
+
+	ns := DefaultNamespaces()
+	ns.Client = "cl"
+	tracer := &recordingTracer{}
+	storage := New(client, "test123", WithTracer(tracer), WithNamespaces(ns))
+
+	assert.NoError(t, storage.CreateClient(newClient()))
+	_, err := storage.GetClient("notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NoError(t, storage.Ping(context.Background()))
+
+	assert.Equal(t, []string{"CreateClient cl false", "GetClient cl true", "Ping  false"}, tracer.spans)
+	assert.Equal(t, []interface{}{"CreateClient", "GetClient", "Ping"}, hook.spans)
+}
@@ -0,0 +1,431 @@
+package osinredis
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/redis/go-redis/v9"
+)
+
+// Option configures a Storage created by New. Options only take effect in
+// New; a Storage cannot be reconfigured once built.
+type Option func(*config)
+
+// WithSerializer sets the Serializer used for clients, authorize data and
+// access data. The default is GobSerializer.
+func WithSerializer(serializer Serializer) Option {
+	return func(c *config) {
+		c.serializer = serializer
+	}
+}
+
+// WithClientTTL makes CreateClient and UpdateClient store clients with the
+// given expiry, e.g. for dynamically registered clients (RFC 7591). Each
+// update restarts the expiry. A zero TTL, the default, stores clients
+// permanently.
+func WithClientTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.clientTTL = ttl
+	}
+}
+
+// WithObserver sets an Observer that is notified of the duration and result
+// of every operation.
+func WithObserver(observer Observer) Option {
+	return func(c *config) {
+		c.observer = observer
+	}
+}
+
+// WithLogger sets a Logger that is told about every failed operation. Lookups
+// of missing keys are not logged. By default nothing is logged.
+func WithLogger(logger Logger) Option {
+	return func(c *config) {
+		c.logger = logger
+	}
+}
+
+// WithCompression gzips payloads before storing them. Compressed payloads are
+// marked with a header byte, and every Storage recognizes it on read whether
+// or not it was created with this option, so data written with and without
+// compression can be mixed during a rolling upgrade.
+func WithCompression() Option {
+	return func(c *config) {
+		c.compress = true
+	}
+}
+
+// WithEncryption encrypts payloads with AES-256-GCM under key, which must be
+// 32 bytes long; otherwise every operation fails with ErrInvalidKey. Each
+// payload gets a random nonce and is marked with a header byte, so values
+// stored before encryption was enabled can still be read while they expire.
+func WithEncryption(key []byte) Option {
+	return func(c *config) {
+		c.encrypted = true
+		c.aead, c.aeadErr = newAEAD(key)
+	}
+}
+
+// WithRefreshTTL lets refresh tokens outlive their access token. SaveAccess
+// keeps the access token for AccessData.ExpiresIn seconds, while the refresh
+// token and the access record it points to are kept for ttl, if that is
+// longer.
+//
+// The ExpiresIn returned by LoadAccess is the remaining life of the access
+// token, and that returned by LoadRefresh the remaining life of the refresh
+// token.
+func WithRefreshTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.refreshTTL = ttl
+	}
+}
+
+// WithKeySeparator sets the separator between the prefix, the namespace and
+// the id of keys, which is ":" by default.
+func WithKeySeparator(sep string) Option {
+	return func(c *config) {
+		c.keySeparator = sep
+	}
+}
+
+// WithKeyFunc replaces the key layout altogether. keyFunc receives the key
+// prefix given to New, a namespace such as "client" or "access_token", and
+// the id within that namespace, and must map distinct inputs to distinct
+// keys. It takes precedence over WithKeySeparator.
+//
+// For example, wrapping the id in braces makes it the Redis Cluster hash tag:
+//
+//	osinredis.WithKeyFunc(func(prefix, namespace, id string) string {
+//		return prefix + ":" + namespace + ":{" + id + "}"
+//	})
+func WithKeyFunc(keyFunc func(prefix, namespace, id string) string) Option {
+	return func(c *config) {
+		c.keyFunc = keyFunc
+	}
+}
+
+// WithKeyEscaping percent-encodes '%', '{', '}' and the bytes of the key
+// separator in the ids of keys, so that client IDs and tokens containing them
+// map to unambiguous keys and cannot alter the Redis Cluster hash tag. Export
+// and the listing methods decode the ids again. With WithKeyFunc, keyFunc
+// receives the encoded id.
+//
+// The option changes the names of keys whose ids contain those characters,
+// which are then no longer found; enable it on a fresh keyspace, or move
+// existing keys with Export and Import.
+func WithKeyEscaping() Option {
+	return func(c *config) {
+		c.escapeIDs = true
+	}
+}
+
+// WithClusterHashTags wraps the key prefix in a Redis Cluster hash tag, as in
+// "{prefix}:access:<id>", so that every key of the storage, including the
+// token pointers and index sets, hashes to the same slot and the
+// transactions and scripts spanning several keys run on a cluster. It is
+// required to pass a *redis.ClusterClient to New. All keys then live on a
+// single node; give tenants that need to spread over the cluster their own
+// prefixes, e.g. with ForTenant. With WithKeyFunc, keyFunc receives the
+// tagged prefix.
+//
+// The option renames every key; enable it on a fresh keyspace, or move
+// existing keys with Export and Import.
+func WithClusterHashTags() Option {
+	return func(c *config) {
+		c.clusterHashTags = true
+	}
+}
+
+// WithClock sets the clock used to time operations for the Observer. Key
+// expiry is measured by the Redis server and is not affected.
+func WithClock(clock Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
+
+// WithIDGenerator sets the generator of the internal access record IDs, which
+// default to random UUIDs.
+func WithIDGenerator(generator IDGenerator) Option {
+	return func(c *config) {
+		c.idGenerator = generator
+	}
+}
+
+// WithUsernameExtractor sets the function Introspect uses to report the
+// resource owner of an access token, typically from its UserData.
+func WithUsernameExtractor(extract func(data *osin.AccessData) string) Option {
+	return func(c *config) {
+		c.usernameExtractor = extract
+	}
+}
+
+// WithEmbeddedClient makes the access loading methods return the client as it
+// was encoded into the access record by SaveAccess, instead of fetching its
+// current version. This saves a round trip per client and keeps tokens
+// loadable after their client is deleted, but updates to a client are not
+// seen by the tokens issued before them.
+func WithEmbeddedClient() Option {
+	return func(c *config) {
+		c.embeddedClient = true
+	}
+}
+
+// WithClientFactory sets the constructor of the concrete osin.Client that
+// stored clients are decoded into, instead of *osin.DefaultClient. It must
+// return a pointer. The type is registered with encoding/gob when the option
+// is applied, so that access and authorize data embedding it can be encoded;
+// a JSONSerializer needs the same constructor passed to WithJSONClientType.
+func WithClientFactory(newClient func() osin.Client) Option {
+	return func(c *config) {
+		registerGobTypes([]interface{}{newClient()})
+		c.newClient = newClient
+	}
+}
+
+// WithDefaultTimeout bounds each call to a method of the osin.Storage
+// interface to d, unless a context was set with WithContext. The methods
+// taking a context use it as is.
+func WithDefaultTimeout(d time.Duration) Option {
+	return func(c *config) {
+		c.defaultTimeout = d
+	}
+}
+
+// WithClientCache caches up to size clients in process for ttl, sparing the
+// client lookup made on every access token load. UpdateClient and
+// DeleteClient invalidate the entry of this storage and its copies, but
+// changes made through other processes are only seen once the entry expires.
+func WithClientCache(size int, ttl time.Duration) Option {
+	return func(c *config) {
+		c.clientCache = newClientCache(size, ttl)
+	}
+}
+
+// WithChecksum makes the storage write a CRC32 checksum with every payload,
+// so that decoding a corrupted one fails with ErrChecksumMismatch rather than
+// an ordinary decoding error. Payloads written without a checksum are still
+// read.
+func WithChecksum() Option {
+	return func(c *config) {
+		c.checksum = true
+	}
+}
+
+// WithNamespaces renames the key namespaces. Start from DefaultNamespaces and
+// override the names to change:
+//
+//	ns := osinredis.DefaultNamespaces()
+//	ns.AccessToken = "ac"
+//	storage := osinredis.New(pool, "prefix", osinredis.WithNamespaces(ns))
+func WithNamespaces(namespaces Namespaces) Option {
+	return func(c *config) {
+		c.namespaces = namespaces
+	}
+}
+
+// WithTracer sets the Tracer that spans every storage operation.
+func WithTracer(tracer Tracer) Option {
+	return func(c *config) {
+		c.tracer = tracer
+	}
+}
+
+// WithOwnedClient hands the client passed to New over to the storage, so that
+// Close closes it. Without it, Close leaves the client to the caller.
+func WithOwnedClient() Option {
+	return func(c *config) {
+		c.ownedClient = true
+	}
+}
+
+// WithMaxPayloadSize makes writes fail with ErrPayloadTooLarge when a value
+// serializes to more than n bytes, before compression or encryption, instead
+// of storing it. A limit of zero, the default, allows any size.
+func WithMaxPayloadSize(n int) Option {
+	return func(c *config) {
+		c.maxPayloadSize = n
+	}
+}
+
+// WithLenientTTL makes loading an access token tolerate a failing TTL
+// command, as on proxies that restrict it: the error is logged and the token
+// keeps its stored ExpiresIn instead of the load failing.
+func WithLenientTTL() Option {
+	return func(c *config) {
+		c.lenientTTL = true
+	}
+}
+
+// WithReadClient sends the load operations, GetClient, LoadAuthorize,
+// LoadAccess, LoadAccessWithTTL, LoadAccessBatch and LoadRefresh, to client,
+// such as one connected to a replica, while every write and delete goes to
+// the client passed to New. Replication is asynchronous, so a client or token
+// may not be found on the replica right after it was written. Close never
+// closes client.
+func WithReadClient(client *redis.Client) Option {
+	return func(c *config) {
+		c.readPool = client
+	}
+}
+
+// WithScanCount sets the COUNT hint passed to SCAN by ListClients, the Count
+// methods, Reap and Export: larger batches take fewer round trips but keep
+// Redis busy longer per call. The default is 100.
+func WithScanCount(n int64) Option {
+	return func(c *config) {
+		c.scanCount = n
+	}
+}
+
+// WithRevokeConcurrency sets how many workers RevokeAllForClient and
+// RevokeAllForUser use, each revoking batches of tokens in pipelined round
+// trips, which speeds up revoking tens of thousands of tokens. The default
+// is 1; values below 1 are ignored.
+func WithRevokeConcurrency(n int) Option {
+	return func(c *config) {
+		if n >= 1 {
+			c.revokeConcurrency = n
+		}
+	}
+}
+
+// WithScanErrorPolicy sets what ListClients, ScanClients, Reap and
+// ReapDryRun do with entries they cannot decode. The default, ScanErrorStop,
+// fails them with the decode error.
+func WithScanErrorPolicy(policy ScanErrorPolicy) Option {
+	return func(c *config) {
+		c.scanErrorPolicy = policy
+	}
+}
+
+// WithRetry retries operations failing with a network error, such as a
+// dropped connection, up to attempts times in all, waiting backoff before the
+// second attempt and twice as long before each further one. Missing keys,
+// Redis error replies and decoding failures are never retried, and retrying
+// stops once the context of the operation is done.
+//
+// Only operations that are safe to repeat are retried: the reads GetClient,
+// GetClients, GetClientMeta, IsClientDisabled, LoadAuthorize, LoadAccess,
+// LoadAccessWithTTL, LoadAccessLite, LoadAccessBatch, LoadRefresh, TokenTTL,
+// RefreshTTL and Introspect, and the plain SETs of SaveAuthorize,
+// SetClientMeta and DisableClient.
+func WithRetry(attempts int, backoff time.Duration) Option {
+	return func(c *config) {
+		c.retryAttempts = attempts
+		c.retryBackoff = backoff
+	}
+}
+
+// WithMinAuthorizeTTL sets the shortest life SaveAuthorize gives an
+// authorization code: a code whose ExpiresIn is shorter, including zero or
+// negative, is saved with ExpiresIn raised to d, rounded up to whole seconds.
+// Without it, SaveAuthorize fails with ErrInvalidExpiry for a non-positive
+// ExpiresIn.
+func WithMinAuthorizeTTL(d time.Duration) Option {
+	return func(c *config) {
+		c.minAuthorizeTTL = d
+	}
+}
+
+// WithHooks sets the callbacks notified when tokens are saved or removed.
+func WithHooks(hooks Hooks) Option {
+	return func(c *config) {
+		c.hooks = hooks
+	}
+}
+
+// WithScopeIndex indexes every access token under each of the scopes it
+// grants, for ListTokensByScope. It costs one SADD per scope on every save
+// and one SREM per scope on every removal, and the index sets take memory
+// for every live token. Tokens saved before it was enabled are not indexed.
+func WithScopeIndex() Option {
+	return func(c *config) {
+		c.scopeIndex = true
+	}
+}
+
+// WithTagIndex indexes the access tokens saved with SaveAccessWithTags under
+// each of their tags, for FindTokensByTag. It costs one SADD per tag on every
+// save, and the index sets take memory for every tagged token until
+// FindTokensByTag finds it gone. Without it the tags are only stored.
+func WithTagIndex() Option {
+	return func(c *config) {
+		c.tagIndex = true
+	}
+}
+
+// WithUserExtractor sets the function finding the user an access token was
+// issued to in its UserData, so that SaveAccess indexes the token under that
+// user for RevokeAllForUser. Tokens for which it returns false are not
+// indexed.
+func WithUserExtractor(extract func(userData interface{}) (string, bool)) Option {
+	return func(c *config) {
+		c.userExtractor = extract
+	}
+}
+
+// WithStatelessAccess is for self-contained access tokens, such as JWTs,
+// which the caller verifies itself. SaveAccess then keeps the access record
+// for the refresh token and the indexes, but writes no access token pointer;
+// for an access token without a refresh token it writes nothing, so such a
+// token is not revoked by RevokeAllForClient and the like. The methods
+// looking up access tokens, LoadAccess, LoadAccessWithTTL, LoadAccessLite,
+// LoadAccessBatch, TokenTTL, TouchAccess, Introspect, ListTokensByScope and
+// FindTokensByTag, fail with ErrStateless.
+func WithStatelessAccess() Option {
+	return func(c *config) {
+		c.statelessAccess = true
+	}
+}
+
+// WithSetNX makes SaveAccess and RotateRefresh fail with ErrTokenCollision
+// instead of overwriting the pointer of an access or refresh token that
+// already exists, such as one generated twice. The check costs a WATCH and
+// an EXISTS per save.
+func WithSetNX() Option {
+	return func(c *config) {
+		c.setNX = true
+	}
+}
+
+// WithMigrateTTL sets the TTL MigrateFixTTLs gives the keys it repairs. The
+// default is one hour.
+func WithMigrateTTL(ttl time.Duration) Option {
+	return func(c *config) {
+		c.migrateTTL = ttl
+	}
+}
+
+// WithTokenKeyHashing names the keys looked up by access and refresh token
+// after h(token) rather than the token itself, so that reading the key names
+// does not reveal usable tokens. h defaults to the hex-encoded SHA-256 if
+// nil. The access records, which hold the tokens, are stored as before;
+// combine with WithEncryption to protect them too.
+//
+// Tokens saved without it are not found once it is enabled.
+func WithTokenKeyHashing(h func(token string) string) Option {
+	if h == nil {
+		h = sha256Hex
+	}
+	return func(c *config) {
+		c.tokenHash = h
+	}
+}
+
+func sha256Hex(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// WithIssuer records iss as the issuer of every access token saved, in a
+// small metadata key next to its access record, for Introspect to report.
+func WithIssuer(iss string) Option {
+	meta, _ := json.Marshal(accessMeta{Issuer: iss})
+	return func(c *config) {
+		c.accessMeta = meta
+	}
+}
@@ -0,0 +1,47 @@
+// Package osinredisotel traces osinredis storage operations with
+// OpenTelemetry. It is a separate module so that osinredis itself does not
+// depend on OpenTelemetry.
+package osinredisotel
+
+import (
+	"context"
+	"errors"
+
+	"github.com/cdyue/osinredis"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/cdyue/osinredis"
+
+// WithTracerProvider makes the storage start a span named after each
+// operation, such as "osinredis.LoadAccess", as a child of the span in the
+// operation's context. Spans record the key namespace and, for failures other
+// than osinredis.ErrNotFound, the error.
+func WithTracerProvider(provider trace.TracerProvider) osinredis.Option {
+	return osinredis.WithTracer(tracer{provider.Tracer(instrumentationName)})
+}
+
+type tracer struct {
+	tracer trace.Tracer
+}
+
+func (t tracer) StartOp(ctx context.Context, op, namespace string) (context.Context, func(err error)) {
+	opts := []trace.SpanStartOption{trace.WithSpanKind(trace.SpanKindClient)}
+	if namespace != "" {
+		opts = append(opts, trace.WithAttributes(attribute.String("osinredis.namespace", namespace)))
+	}
+	ctx, span := t.tracer.Start(ctx, "osinredis."+op, opts...)
+
+	return ctx, func(err error) {
+		switch {
+		case errors.Is(err, osinredis.ErrNotFound):
+			span.SetAttributes(attribute.Bool("osinredis.miss", true))
+		case err != nil:
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		span.End()
+	}
+}
@@ -0,0 +1,56 @@
+package osinredisotel
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/cdyue/osinredis"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+var pool = redis.NewClient(&redis.Options{
+	Addr: os.Getenv("REDIS_ADDR"),
+})
+
+func TestWithTracerProvider(t *testing.T) {
+	ctx := context.Background()
+	assert.NoError(t, pool.FlushAll(ctx).Err())
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	storage := osinredis.New(pool, "test123", WithTracerProvider(provider))
+
+	parentCtx, parent := provider.Tracer("test").Start(ctx, "parent")
+	client := &osin.DefaultClient{Id: "clientID", Secret: "secret", RedirectUri: "http://localhost/"}
+	assert.NoError(t, storage.CreateClientContext(parentCtx, client))
+	_, err := storage.GetClientContext(parentCtx, "notthere")
+	assert.ErrorIs(t, err, osinredis.ErrNotFound)
+	parent.End()
+
+	canceled, cancel := context.WithCancel(parentCtx)
+	cancel()
+	assert.Error(t, storage.Ping(canceled))
+
+	spans := recorder.Ended()
+	assert.Len(t, spans, 4)
+
+	create, get, ping := spans[0], spans[1], spans[3]
+	assert.Equal(t, "osinredis.CreateClient", create.Name())
+	assert.Equal(t, parent.SpanContext().SpanID(), create.Parent().SpanID())
+	assert.Contains(t, create.Attributes(), attribute.String("osinredis.namespace", "client"))
+	assert.Equal(t, codes.Unset, create.Status().Code)
+
+	assert.Equal(t, "osinredis.GetClient", get.Name())
+	assert.Contains(t, get.Attributes(), attribute.Bool("osinredis.miss", true))
+	assert.Equal(t, codes.Unset, get.Status().Code)
+
+	assert.Equal(t, "osinredis.Ping", ping.Name())
+	assert.Equal(t, codes.Error, ping.Status().Code)
+}
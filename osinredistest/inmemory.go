@@ -0,0 +1,309 @@
+// Package osinredistest provides an in-memory osin.Storage that behaves like
+// osinredis.Storage, for unit-testing OAuth flows without a Redis server.
+package osinredistest
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/cdyue/osinredis"
+	"github.com/pkg/errors"
+)
+
+// InMemory is an osin.Storage kept in process memory. Like osinredis.Storage,
+// it stores values encoded with osinredis.GobSerializer, expires authorization
+// codes and tokens after their ExpiresIn, reports the remaining life of an
+// access token as its ExpiresIn and returns errors wrapping
+// osinredis.ErrNotFound for missing entries.
+//
+// Custom user data types must be registered with osinredis.RegisterUserData.
+type InMemory struct {
+	mu         sync.Mutex
+	serializer osinredis.Serializer
+	now        func() time.Time
+	nextID     int
+
+	clients  map[string]entry
+	auth     map[string]entry
+	revoked  map[string]entry
+	access   map[string]entry
+	pointers map[string]entry
+}
+
+type entry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e entry) live(now time.Time) bool {
+	return e.expires.IsZero() || now.Before(e.expires)
+}
+
+var _ osin.Storage = (*InMemory)(nil)
+
+// NewInMemory returns an empty InMemory storage.
+func NewInMemory() *InMemory {
+	return &InMemory{
+		serializer: osinredis.GobSerializer{},
+		now:        time.Now,
+		clients:    make(map[string]entry),
+		auth:       make(map[string]entry),
+		revoked:    make(map[string]entry),
+		access:     make(map[string]entry),
+		pointers:   make(map[string]entry),
+	}
+}
+
+// Clone returns the storage itself; it is safe for concurrent use.
+func (m *InMemory) Clone() osin.Storage {
+	return m
+}
+
+// Close is a no-op.
+func (m *InMemory) Close() {}
+
+// CreateClient inserts a new client
+func (m *InMemory) CreateClient(client osin.Client) error {
+	return m.putClient(client)
+}
+
+// UpdateClient updates a client
+func (m *InMemory) UpdateClient(client osin.Client) error {
+	return errors.Wrap(m.putClient(client), "failed to update client")
+}
+
+func (m *InMemory) putClient(client osin.Client) error {
+	if client.GetId() == "" {
+		return osinredis.ErrInvalidClientID
+	}
+	payload, err := m.serializer.Marshal(client)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode client")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.clients[client.GetId()] = entry{value: payload}
+	return nil
+}
+
+// GetClient gets a client by ID
+func (m *InMemory) GetClient(id string) (osin.Client, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.getClient(id)
+}
+
+func (m *InMemory) getClient(id string) (osin.Client, error) {
+	e, ok := m.get(m.clients, id)
+	if !ok {
+		return nil, errors.Wrap(osinredis.ErrNotFound, "unable to GET client")
+	}
+
+	var client osin.DefaultClient
+	if err := m.serializer.Unmarshal(e.value, &client); err != nil {
+		return nil, errors.Wrap(err, "failed to decode client")
+	}
+	return &client, nil
+}
+
+// DeleteClient deletes given client
+func (m *InMemory) DeleteClient(client osin.Client) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.clients, client.GetId())
+	return nil
+}
+
+// SaveAuthorize saves authorize data.
+func (m *InMemory) SaveAuthorize(data *osin.AuthorizeData) error {
+	if data.Code == "" {
+		return osinredis.ErrInvalidToken
+	}
+	if data.ExpiresIn <= 0 {
+		return errors.Wrapf(osinredis.ErrInvalidExpiry, "authorize data expires in %d seconds", data.ExpiresIn)
+	}
+	payload, err := m.serializer.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode data")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.auth[data.Code] = entry{value: payload, expires: m.expiry(data.ExpiresIn)}
+	return nil
+}
+
+// LoadAuthorize looks up AuthorizeData by a code.
+func (m *InMemory) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(m.auth, code)
+	if !ok {
+		if _, revoked := m.get(m.revoked, code); revoked {
+			return nil, errors.Wrap(osinredis.ErrRevoked, "unable to GET auth")
+		}
+		return nil, errors.Wrap(osinredis.ErrNotFound, "unable to GET auth")
+	}
+
+	var auth osin.AuthorizeData
+	err := m.serializer.Unmarshal(e.value, &auth)
+	return &auth, errors.Wrap(err, "failed to decode auth")
+}
+
+// RemoveAuthorize revokes or deletes the authorization code.
+func (m *InMemory) RemoveAuthorize(code string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// Like osinredis.Storage, leave a tombstone for as long as the code
+	// would have lived.
+	if e, ok := m.get(m.auth, code); ok {
+		m.revoked[code] = entry{expires: e.expires}
+		delete(m.auth, code)
+	}
+	return nil
+}
+
+// SaveAccess creates AccessData.
+func (m *InMemory) SaveAccess(data *osin.AccessData) error {
+	if data.AccessToken == "" {
+		return osinredis.ErrInvalidToken
+	}
+	payload, err := m.serializer.Marshal(data)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode access")
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.nextID++
+	accessID := strconv.Itoa(m.nextID)
+	expires := m.expiry(data.ExpiresIn)
+
+	m.access[accessID] = entry{value: payload, expires: expires}
+	m.pointers["access_token:"+data.AccessToken] = entry{value: []byte(accessID), expires: expires}
+	if data.RefreshToken != "" {
+		m.pointers["refresh_token:"+data.RefreshToken] = entry{value: []byte(accessID), expires: expires}
+	}
+	return nil
+}
+
+// LoadAccess gets access data with given access token
+func (m *InMemory) LoadAccess(token string) (*osin.AccessData, error) {
+	return m.loadAccess("access_token:" + token)
+}
+
+// RemoveAccess deletes AccessData with given access token. Removing a token
+// that does not exist succeeds.
+func (m *InMemory) RemoveAccess(token string) error {
+	return m.removeAccess("access_token:" + token)
+}
+
+// LoadRefresh gets access data with given refresh token
+func (m *InMemory) LoadRefresh(token string) (*osin.AccessData, error) {
+	return m.loadAccess("refresh_token:" + token)
+}
+
+// RemoveRefresh deletes AccessData with given refresh token. Removing a token
+// that does not exist succeeds.
+func (m *InMemory) RemoveRefresh(token string) error {
+	return m.removeAccess("refresh_token:" + token)
+}
+
+func (m *InMemory) loadAccess(key string) (*osin.AccessData, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pointer, ok := m.get(m.pointers, key)
+	if !ok {
+		return nil, errors.Wrap(osinredis.ErrNotFound, "unable to get access ID")
+	}
+	e, ok := m.get(m.access, string(pointer.value))
+	if !ok {
+		return nil, errors.Wrap(osinredis.ErrNotFound, "unable to get access gob")
+	}
+
+	var access osin.AccessData
+	if err := m.serializer.Unmarshal(e.value, &access); err != nil {
+		return nil, errors.Wrap(err, "failed to decode access gob")
+	}
+
+	if !e.expires.IsZero() {
+		access.ExpiresIn = int32(e.expires.Sub(m.now()).Seconds())
+	}
+
+	if access.Client != nil {
+		client, err := m.getCurrentClient(access.Client)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get client for access")
+		}
+		access.Client = client
+	}
+
+	if access.AuthorizeData != nil && access.AuthorizeData.Client != nil {
+		client, err := m.getCurrentClient(access.AuthorizeData.Client)
+		if err != nil {
+			return nil, errors.Wrap(err, "unable to get client for access authorize data")
+		}
+		access.AuthorizeData.Client = client
+	}
+
+	return &access, nil
+}
+
+// getCurrentClient returns the stored version of the decoded client, or the
+// decoded client itself if it has been deleted.
+func (m *InMemory) getCurrentClient(decoded osin.Client) (osin.Client, error) {
+	client, err := m.getClient(decoded.GetId())
+	if errors.Is(err, osinredis.ErrNotFound) {
+		return decoded, nil
+	}
+	return client, err
+}
+
+func (m *InMemory) removeAccess(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	pointer, ok := m.get(m.pointers, key)
+	if !ok {
+		return nil
+	}
+	delete(m.pointers, key)
+
+	accessID := string(pointer.value)
+	e, ok := m.get(m.access, accessID)
+	if !ok {
+		return nil
+	}
+	delete(m.access, accessID)
+
+	var access osin.AccessData
+	if err := m.serializer.Unmarshal(e.value, &access); err != nil {
+		return errors.Wrap(err, "unable to load access for removal")
+	}
+	delete(m.pointers, "access_token:"+access.AccessToken)
+	if access.RefreshToken != "" {
+		delete(m.pointers, "refresh_token:"+access.RefreshToken)
+	}
+	return nil
+}
+
+// get returns the live entry under key, dropping it if it has expired.
+func (m *InMemory) get(entries map[string]entry, key string) (entry, bool) {
+	e, ok := entries[key]
+	if ok && !e.live(m.now()) {
+		delete(entries, key)
+		return entry{}, false
+	}
+	return e, ok
+}
+
+func (m *InMemory) expiry(expiresIn int32) time.Time {
+	return m.now().Add(time.Duration(expiresIn) * time.Second)
+}
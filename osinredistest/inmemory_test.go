@@ -0,0 +1,116 @@
+package osinredistest
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/cdyue/osinredis"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestData() (*osin.DefaultClient, *osin.AuthorizeData, *osin.AccessData) {
+	client := &osin.DefaultClient{Id: "clientID", Secret: "secret", RedirectUri: "http://localhost/", UserData: make(map[string]interface{})}
+	auth := &osin.AuthorizeData{
+		Client:      client,
+		Code:        "8888",
+		ExpiresIn:   3600,
+		CreatedAt:   time.Now().Round(0),
+		RedirectUri: "http://localhost/",
+	}
+	access := &osin.AccessData{
+		Client:        client,
+		AuthorizeData: auth,
+		AccessToken:   "8888",
+		RefreshToken:  "r8888",
+		ExpiresIn:     3600,
+		CreatedAt:     time.Now().Round(0),
+	}
+	return client, auth, access
+}
+
+func TestInMemory(t *testing.T) {
+	storage := NewInMemory()
+	client, auth, access := newTestData()
+
+	assert.NoError(t, storage.CreateClient(client))
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	assert.NoError(t, storage.SaveAuthorize(auth))
+	authFound, err := storage.LoadAuthorize(auth.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, auth, authFound)
+	assert.NoError(t, storage.RemoveAuthorize(auth.Code))
+	_, err = storage.LoadAuthorize(auth.Code)
+	assert.True(t, errors.Is(err, osinredis.ErrRevoked))
+	_, err = storage.LoadAuthorize("unknown")
+	assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+	assert.False(t, errors.Is(err, osinredis.ErrRevoked))
+
+	assert.NoError(t, storage.SaveAccess(access))
+	accessFound, err := storage.LoadAccess(access.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, access.RefreshToken, accessFound.RefreshToken)
+	assert.Equal(t, client, accessFound.Client)
+
+	accessFound, err = storage.LoadRefresh(access.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, access.AccessToken, accessFound.AccessToken)
+
+	assert.NoError(t, storage.RemoveRefresh(access.RefreshToken))
+	_, err = storage.LoadAccess(access.AccessToken)
+	assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+	assert.NoError(t, storage.RemoveAccess(access.AccessToken))
+
+	assert.NoError(t, storage.DeleteClient(client))
+	_, err = storage.GetClient(client.GetId())
+	assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+}
+
+func TestInMemoryExpiry(t *testing.T) {
+	storage := NewInMemory()
+	now := time.Now()
+	storage.now = func() time.Time { return now }
+
+	client, auth, access := newTestData()
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAuthorize(auth))
+	assert.NoError(t, storage.SaveAccess(access))
+
+	now = now.Add(time.Hour - time.Minute)
+	accessFound, err := storage.LoadAccess(access.AccessToken)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 60, accessFound.ExpiresIn)
+
+	now = now.Add(time.Minute)
+	_, err = storage.LoadAuthorize(auth.Code)
+	assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+	_, err = storage.LoadAccess(access.AccessToken)
+	assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+	_, err = storage.LoadRefresh(access.RefreshToken)
+	assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+}
+
+func TestInMemoryEmptyIdentifiers(t *testing.T) {
+	storage := NewInMemory()
+
+	assert.True(t, errors.Is(storage.CreateClient(&osin.DefaultClient{}), osinredis.ErrInvalidClientID))
+	assert.True(t, errors.Is(storage.SaveAuthorize(&osin.AuthorizeData{ExpiresIn: 60}), osinredis.ErrInvalidToken))
+	assert.True(t, errors.Is(storage.SaveAccess(&osin.AccessData{ExpiresIn: 60}), osinredis.ErrInvalidToken))
+}
+
+func TestInMemoryNonPositiveExpiry(t *testing.T) {
+	storage := NewInMemory()
+	_, auth, _ := newTestData()
+
+	for _, expiresIn := range []int32{0, -1} {
+		auth.ExpiresIn = expiresIn
+		err := storage.SaveAuthorize(auth)
+		assert.True(t, errors.Is(err, osinredis.ErrInvalidExpiry), "ExpiresIn %d", expiresIn)
+		_, err = storage.LoadAuthorize(auth.Code)
+		assert.True(t, errors.Is(err, osinredis.ErrNotFound))
+	}
+}
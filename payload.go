@@ -0,0 +1,117 @@
+package osinredis
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// A stored payload may start with a one-byte header telling how the
+// serialized value was transformed before it was written. Headers are taken
+// from the range 0x80-0xf7, which never starts a gob stream or a JSON
+// document, so values written without a header still decode as before.
+//
+// Headers nest: a checksum covers the rest of the payload, an encrypted
+// payload decrypts to one that may itself be compressed, which decompresses
+// to the serialized value behind a schema version header. Values without a
+// version header predate it and are decoded as version 1.
+const (
+	headerSchemaV1 byte = 0xc0
+	headerGzip     byte = 0xc1
+	headerAESGCM   byte = 0xc2
+	headerCRC32    byte = 0xc3
+)
+
+// ErrChecksumMismatch is returned when a payload written with WithChecksum
+// does not match its checksum, i.e. it was corrupted in storage.
+var ErrChecksumMismatch = errors.New("osinredis: payload checksum mismatch")
+
+func (s *Storage) encode(v interface{}) ([]byte, error) {
+	data, err := s.serializer.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if s.maxPayloadSize > 0 && len(data) > s.maxPayloadSize {
+		return nil, errors.Wrapf(ErrPayloadTooLarge, "%d bytes exceeds the limit of %d", len(data), s.maxPayloadSize)
+	}
+	data = append([]byte{headerSchemaV1}, data...)
+	if s.compress {
+		if data, err = compress(data); err != nil {
+			return nil, err
+		}
+	}
+	if s.encrypted {
+		if data, err = s.encrypt(data); err != nil {
+			return nil, err
+		}
+	}
+	if s.checksum {
+		data = addChecksum(data)
+	}
+	return data, nil
+}
+
+func (s *Storage) decode(data []byte, v interface{}) error {
+	for len(data) > 0 {
+		var err error
+		switch data[0] {
+		case headerGzip:
+			data, err = decompress(data[1:])
+		case headerAESGCM:
+			data, err = s.decrypt(data[1:])
+		case headerCRC32:
+			data, err = verifyChecksum(data[1:])
+		case headerSchemaV1:
+			return s.serializer.Unmarshal(data[1:], v)
+		default:
+			return s.serializer.Unmarshal(data, v)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return s.serializer.Unmarshal(data, v)
+}
+
+func compress(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte(headerGzip)
+
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(data); err != nil {
+		return nil, errors.Wrap(err, "unable to compress")
+	}
+	if err := w.Close(); err != nil {
+		return nil, errors.Wrap(err, "unable to compress")
+	}
+	return buf.Bytes(), nil
+}
+
+func decompress(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to decompress")
+	}
+	defer r.Close()
+
+	data, err = io.ReadAll(r)
+	return data, errors.Wrap(err, "unable to decompress")
+}
+
+func addChecksum(data []byte) []byte {
+	out := make([]byte, 5, 5+len(data))
+	out[0] = headerCRC32
+	binary.BigEndian.PutUint32(out[1:], crc32.ChecksumIEEE(data))
+	return append(out, data...)
+}
+
+func verifyChecksum(data []byte) ([]byte, error) {
+	if len(data) < 4 || binary.BigEndian.Uint32(data) != crc32.ChecksumIEEE(data[4:]) {
+		return nil, ErrChecksumMismatch
+	}
+	return data[4:], nil
+}
@@ -0,0 +1,129 @@
+package osinredis
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func getRawAccess(t *testing.T, storage *Storage, token string) []byte {
+	ctx := context.Background()
+	accessID, err := pool.Get(ctx, storage.makeKey("access_token", token)).Result()
+	assert.NoError(t, err)
+	raw, err := pool.Get(ctx, storage.makeKey("access", accessID)).Bytes()
+	assert.NoError(t, err)
+	return raw
+}
+
+func TestWithCompression(t *testing.T) {
+	flushAll()
+
+	plain := initTestStorage()
+	compressed := New(pool, "test123", WithCompression())
+
+	client := newClient()
+	assert.NoError(t, plain.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.UserData = map[string]interface{}{"blob": strings.Repeat("x", 4096)}
+	assert.NoError(t, plain.SaveAccess(accessData))
+	plainSize := len(getRawAccess(t, plain, accessData.AccessToken))
+
+	large := newAccessData(newAuthorizeData(client))
+	large.AccessToken = "compressed"
+	large.RefreshToken = "rcompressed"
+	large.UserData = accessData.UserData
+	assert.NoError(t, compressed.SaveAccess(large))
+	raw := getRawAccess(t, compressed, large.AccessToken)
+	assert.Equal(t, headerGzip, raw[0])
+	assert.Less(t, len(raw), plainSize/4)
+
+	// Both kinds of payload are readable through either storage.
+	for _, storage := range []*Storage{plain, compressed} {
+		loadData, err := storage.LoadAccess(accessData.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, accessData.UserData, loadData.UserData)
+
+		loadData, err = storage.LoadAccess(large.AccessToken)
+		assert.NoError(t, err)
+		assert.Equal(t, large.UserData, loadData.UserData)
+	}
+}
+
+func TestSchemaVersionHeader(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	ctx := context.Background()
+	key := storage.makeKey("client", client.GetId())
+	raw, err := pool.Get(ctx, key).Bytes()
+	assert.NoError(t, err)
+	assert.Equal(t, headerSchemaV1, raw[0])
+
+	// Values written before the header was introduced still decode.
+	legacy, err := GobSerializer{}.Marshal(client)
+	assert.NoError(t, err)
+	assert.NoError(t, pool.Set(ctx, key, legacy, 0).Err())
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+}
+
+func TestWithChecksum(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithChecksum())
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	raw := getRawAccess(t, storage, accessData.AccessToken)
+	assert.Equal(t, headerCRC32, raw[0])
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	// Payloads written without a checksum are still read.
+	assert.NoError(t, initTestStorage().CreateClient(client))
+	_, err = storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+
+	key := storage.makeKey("client", client.GetId())
+	assert.NoError(t, storage.CreateClient(client))
+	raw, err = pool.Get(ctx, key).Bytes()
+	assert.NoError(t, err)
+	raw[len(raw)-1] ^= 0xff
+	assert.NoError(t, pool.Set(ctx, key, raw, 0).Err())
+
+	_, err = storage.GetClient(client.GetId())
+	assert.ErrorIs(t, err, ErrChecksumMismatch)
+}
+
+func TestMaxPayloadSize(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithMaxPayloadSize(4096))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	authorizeData.UserData = strings.Repeat("x", 4096)
+	assert.ErrorIs(t, storage.SaveAuthorize(authorizeData), ErrPayloadTooLarge)
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	accessData.AccessToken = "large"
+	accessData.UserData = strings.Repeat("x", 4096)
+	assert.ErrorIs(t, storage.SaveAccess(accessData), ErrPayloadTooLarge)
+
+	_, err := storage.LoadAccess("large")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
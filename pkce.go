@@ -0,0 +1,38 @@
+package osinredis
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+)
+
+// ErrPKCEMismatch is returned by ValidatePKCE and ConsumeAuthorizeWithPKCE
+// when the code verifier does not match the stored code challenge.
+var ErrPKCEMismatch = errors.New("osinredis: code verifier does not match code challenge")
+
+// ValidatePKCE checks verifier against the PKCE code challenge stored in data,
+// as described in RFC 7636 section 4.6. Authorize data without a code
+// challenge always validates.
+func ValidatePKCE(data *osin.AuthorizeData, verifier string) error {
+	if data.CodeChallenge == "" {
+		return nil
+	}
+
+	var challenge string
+	switch data.CodeChallengeMethod {
+	case "", osin.PKCE_PLAIN:
+		challenge = verifier
+	case osin.PKCE_S256:
+		hash := sha256.Sum256([]byte(verifier))
+		challenge = base64.RawURLEncoding.EncodeToString(hash[:])
+	default:
+		return errors.Errorf("osinredis: unsupported code challenge method %q", data.CodeChallengeMethod)
+	}
+
+	if !SecureCompare(challenge, data.CodeChallenge) {
+		return ErrPKCEMismatch
+	}
+	return nil
+}
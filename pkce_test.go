@@ -0,0 +1,110 @@
+package osinredis
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+
+	"github.com/RangelReale/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+// The verifier and challenge from RFC 7636 appendix B.
+const (
+	testVerifier  = "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	testChallenge = "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+)
+
+func TestLoadAuthorizePKCE(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	authorizeData.CodeChallenge = testChallenge
+	authorizeData.CodeChallengeMethod = osin.PKCE_S256
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	loadData, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.Equal(t, testChallenge, loadData.CodeChallenge)
+	assert.Equal(t, osin.PKCE_S256, loadData.CodeChallengeMethod)
+	assert.NoError(t, ValidatePKCE(loadData, testVerifier))
+}
+
+func TestValidatePKCE(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		challenge string
+		method    string
+		verifier  string
+		err       error
+	}{
+		{"no challenge", "", "", "anything", nil},
+		{"S256", testChallenge, osin.PKCE_S256, testVerifier, nil},
+		{"S256 mismatch", testChallenge, osin.PKCE_S256, testVerifier + "x", ErrPKCEMismatch},
+		{"plain", testVerifier, osin.PKCE_PLAIN, testVerifier, nil},
+		{"default plain", testVerifier, "", testVerifier, nil},
+		{"plain mismatch", testVerifier, osin.PKCE_PLAIN, testChallenge, ErrPKCEMismatch},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data := &osin.AuthorizeData{CodeChallenge: tt.challenge, CodeChallengeMethod: tt.method}
+			assert.Equal(t, tt.err, ValidatePKCE(data, tt.verifier))
+		})
+	}
+
+	data := &osin.AuthorizeData{CodeChallenge: testChallenge, CodeChallengeMethod: "S512"}
+	assert.Error(t, ValidatePKCE(data, testVerifier))
+}
+
+func TestConsumeAuthorizeWithPKCE(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	authorizeData.CodeChallenge = testChallenge
+	authorizeData.CodeChallengeMethod = osin.PKCE_S256
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	_, err := storage.ConsumeAuthorizeWithPKCE(ctx, authorizeData.Code, testVerifier+"x")
+	assert.ErrorIs(t, err, ErrPKCEMismatch)
+	_, err = storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		consumed int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := storage.ConsumeAuthorizeWithPKCE(ctx, authorizeData.Code, testVerifier)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				consumed++
+				assert.Equal(t, authorizeData, data)
+			} else {
+				assert.ErrorIs(t, err, ErrRevoked)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, consumed)
+
+	_, err = storage.LoadAuthorize(authorizeData.Code)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	_, err = storage.ConsumeAuthorizeWithPKCE(ctx, "unknown", testVerifier)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, errors.Is(err, ErrRevoked))
+}
@@ -0,0 +1,40 @@
+package osinredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// allowIssueScript increments the counter KEYS[1], starting its window of
+// ARGV[1] milliseconds on the first increment, and returns the new count.
+var allowIssueScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+if count == 1 or redis.call("PTTL", KEYS[1]) == -1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return count
+`)
+
+// AllowIssue counts an issuance of a token to clientID and reports whether
+// the client is still within limit issuances per window. The window is fixed:
+// it starts with the first issuance counted and the count resets once it has
+// passed. The storage does not call it itself; call it before issuing a
+// token, e.g.
+//
+//	if ok, err := storage.AllowIssue(ctx, ar.Client.GetId(), 100, time.Minute); err == nil && !ok {
+//		// reject the request
+//	}
+func (s *Storage) AllowIssue(ctx context.Context, clientID string, limit int, window time.Duration) (_ bool, err error) {
+	ctx, done := s.begin(ctx, "AllowIssue")
+	defer done(&err)
+
+	key := s.makeKey("ratelimit", clientID)
+	count, err := allowIssueScript.Run(ctx, s.pool, []string{key}, window.Milliseconds()).Int64()
+	if err != nil {
+		return false, keyError(key, errors.Wrap(err, "unable to count issuance"))
+	}
+	return count <= int64(limit), nil
+}
@@ -0,0 +1,33 @@
+package osinredis
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAllowIssue(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+
+	for i := 0; i < 3; i++ {
+		ok, err := storage.AllowIssue(ctx, "clientID", 3, time.Minute)
+		assert.NoError(t, err)
+		assert.True(t, ok)
+	}
+	ok, err := storage.AllowIssue(ctx, "clientID", 3, time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	// Other clients have their own counters.
+	ok, err = storage.AllowIssue(ctx, "otherClientID", 3, time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	ttl := pool.PTTL(ctx, "test123:ratelimit:clientID").Val()
+	assert.True(t, ttl > 0 && ttl <= time.Minute, ttl)
+}
@@ -0,0 +1,180 @@
+package osinredis
+
+import (
+	"context"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// ReapStats counts the keys removed by Reap.
+type ReapStats struct {
+	AccessTokens  int // access_token pointers to a missing access record
+	RefreshTokens int // refresh_token pointers to a missing access record
+	Records       int // access records no token points to
+}
+
+// reapPointerScript deletes the pointer KEYS[1] if it still resolves to the
+// access ID in ARGV[1] and the access record KEYS[2] does not exist.
+var reapPointerScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] or redis.call("EXISTS", KEYS[2]) == 1 then
+	return 0
+end
+return redis.call("DEL", KEYS[1])
+`)
+
+// reapRecordScript deletes an access record unless the access or refresh token
+// pointer in KEYS[1] and KEYS[2] resolves to its access ID in ARGV[1]. KEYS[3]
+// is the record's access_refresh set, whose ARGV[3] members' pointers follow;
+// the record is also kept if one of those resolves to it, or if the set no
+// longer has ARGV[3] members, as when a refresh token was added meanwhile. The
+// next ARGV[2] keys are index sets the access ID is removed from; the
+// remaining keys, the first of which is the record, are deleted. If ARGV[4]
+// is 1, for stateless access tokens that have no pointer, a record that has
+// not reached its expiry is kept as well.
+var reapRecordScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] or redis.call("GET", KEYS[2]) == ARGV[1] then
+	return 0
+end
+local members = tonumber(ARGV[3])
+if redis.call("SCARD", KEYS[3]) ~= members then
+	return 0
+end
+for i = 4, members + 3 do
+	if redis.call("GET", KEYS[i]) == ARGV[1] then
+		return 0
+	end
+end
+local first = members + 4
+local sets = tonumber(ARGV[2])
+if ARGV[4] == "1" and redis.call("PTTL", KEYS[first + sets]) > 0 then
+	return 0
+end
+for i = first, first + sets - 1 do
+	redis.call("SREM", KEYS[i], ARGV[1])
+end
+return redis.call("DEL", unpack(KEYS, first + sets))
+`)
+
+// Reap removes the token pointers whose access record is gone and the access
+// records no token points to anymore, which interrupted writes of earlier
+// releases could leave behind. Under WithStatelessAccess, which writes no
+// access token pointers, a record is kept until it expires. Every deletion is re-checked atomically, so it
+// is safe to run periodically against a live server. Like CountAccessTokens,
+// it iterates the whole keyspace with SCAN. ReapDryRun lists the keys it
+// would delete.
+func (s *Storage) Reap(ctx context.Context) (_ ReapStats, err error) {
+	ctx, done := s.begin(ctx, "Reap")
+	defer done(&err)
+
+	var stats ReapStats
+	if stats.AccessTokens, err = s.reapPointers(ctx, "access_token"); err != nil {
+		return stats, err
+	}
+	if stats.RefreshTokens, err = s.reapPointers(ctx, "refresh_token"); err != nil {
+		return stats, err
+	}
+	sk := &skipper{policy: s.scanErrorPolicy}
+	if stats.Records, err = s.reapRecords(ctx, sk); err != nil {
+		return stats, err
+	}
+	return stats, sk.err()
+}
+
+func (s *Storage) reapPointers(ctx context.Context, namespace string) (int, error) {
+	reaped := 0
+	err := s.scanKeys(ctx, namespace, func(keys []string) error {
+		values, err := s.pool.MGet(ctx, keys...).Result()
+		if err != nil {
+			return errors.Wrapf(err, "unable to MGET %s", namespace)
+		}
+		for i, value := range values {
+			accessID, ok := value.(string)
+			if !ok {
+				continue
+			}
+			deleted, err := reapPointerScript.Run(ctx, s.pool, []string{keys[i], s.makeKey("access", accessID)}, accessID).Int()
+			if err != nil {
+				return errors.Wrapf(err, "unable to reap %s", namespace)
+			}
+			reaped += deleted
+		}
+		return nil
+	})
+	return reaped, err
+}
+
+func (s *Storage) reapRecords(ctx context.Context, sk *skipper) (int, error) {
+	reaped := 0
+	err := s.scanKeys(ctx, "access", func(keys []string) error {
+		for _, key := range keys {
+			accessID, ok := s.keyID("access", key)
+			if !ok {
+				continue
+			}
+			access, err := s.scannedAccess(ctx, accessID, sk)
+			if err != nil {
+				return err
+			}
+			if access == nil {
+				continue
+			}
+
+			accessKey := s.tokenKey("access_token", access.AccessToken)
+			refreshKey := accessKey
+			if access.RefreshToken != "" {
+				refreshKey = s.tokenKey("refresh_token", access.RefreshToken)
+			}
+			refreshSetKey := s.makeKey("access_refresh", accessID)
+			refreshIDs, err := s.pool.SMembers(ctx, refreshSetKey).Result()
+			if err != nil {
+				return errors.Wrap(err, "unable to get refresh tokens of access")
+			}
+			sets := s.indexSets(access)
+			keys := append([]string{accessKey, refreshKey, refreshSetKey}, s.refreshPointerKeys(refreshIDs)...)
+			keys = append(keys, sets...)
+			keys = append(keys, key, s.makeKey("access_family", accessID), s.makeKey("access_meta", accessID),
+				refreshSetKey, s.makeKey("access_tags", accessID))
+			deleted, err := reapRecordScript.Run(ctx, s.pool, keys, accessID, len(sets), len(refreshIDs), s.statelessAccess).Int()
+			if err != nil {
+				return errors.Wrap(err, "unable to reap access")
+			}
+			if deleted > 0 {
+				reaped++
+			}
+		}
+		return nil
+	})
+	return reaped, err
+}
+
+// refreshPointerKeys returns the refresh token pointers of the members
+// refreshIDs of an access_refresh set.
+func (s *Storage) refreshPointerKeys(refreshIDs []string) []string {
+	keys := make([]string, len(refreshIDs))
+	for i, refreshID := range refreshIDs {
+		keys[i] = s.makeKey("refresh_token", refreshID)
+	}
+	return keys
+}
+
+// scannedAccess loads the access record accessID found by a walk of the
+// keyspace. It returns nil if the record is gone or, under ScanErrorSkip,
+// cannot be decoded.
+func (s *Storage) scannedAccess(ctx context.Context, accessID string, sk *skipper) (*osin.AccessData, error) {
+	key := s.makeKey("access", accessID)
+	raw, err := s.pool.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, keyError(key, errors.Wrap(err, "unable to load access for reaping"))
+	}
+
+	var access osin.AccessData
+	if err := s.decode(raw, &access); err != nil {
+		return nil, sk.skip(key, keyError(key, errors.Wrap(err, "failed to decode access gob")))
+	}
+	return &access, nil
+}
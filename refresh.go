@@ -0,0 +1,224 @@
+package osinredis
+
+import (
+	"context"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// Every access record belongs to a refresh token family: SaveAccess starts a
+// new family, identified by the ID of its first access record, and
+// RotateRefresh adds the new record to the family of the token it consumes.
+// Consumed refresh tokens are remembered until they would have expired, so a
+// replayed token can be detected and its whole family revoked, as recommended
+// by the OAuth 2.0 Security BCP:
+//
+//	reused, err := storage.DetectRefreshReuse(ctx, token)
+//	if reused {
+//		familyID, _ := storage.RefreshFamily(ctx, token)
+//		storage.RevokeFamily(ctx, familyID)
+//	}
+
+// RotateRefresh consumes oldRefreshToken and saves newData in its place. The
+// old refresh pointer is replaced by a consumed marker and the new access
+// record, access token and refresh token are written in one transaction, so
+// each refresh token can be exchanged at most once: a second rotation of the
+// same token, including a concurrent one, fails with ErrNotFound.
+//
+// The access record the old refresh token pointed to is left in place; remove
+// it with RemoveAccess if it should not outlive the rotation.
+func (s *Storage) RotateRefresh(ctx context.Context, oldRefreshToken string, newData *osin.AccessData) (err error) {
+	ctx, done := s.begin(ctx, "RotateRefresh")
+	defer done(&err)
+	if newData.AccessToken == "" {
+		return ErrInvalidToken
+	}
+
+	payload, err := s.encode(newData)
+	if err != nil {
+		return errors.Wrap(err, "failed to encode access")
+	}
+
+	accessID, err := s.idGenerator.NewID()
+	if err != nil {
+		return errors.Wrap(err, "failed to generate access ID")
+	}
+	oldKey := s.tokenKey("refresh_token", oldRefreshToken)
+	watched := []string{oldKey}
+	if s.setNX {
+		watched = append(watched, s.pointerKeys(newData)...)
+	}
+
+	err = s.pool.Watch(ctx, func(tx *redis.Tx) error {
+		oldAccessID, err := s.getAccessIDWith(ctx, tx, oldKey)
+		if err != nil {
+			return err
+		}
+		if s.setNX {
+			if err := checkCollision(ctx, tx, watched[1:]); err != nil {
+				return err
+			}
+		}
+
+		familyID, err := tx.Get(ctx, s.makeKey("access_family", oldAccessID)).Result()
+		if errors.Is(err, redis.Nil) {
+			// Records saved before families existed start their own.
+			familyID = oldAccessID
+		} else if err != nil {
+			return errors.Wrap(err, "unable to get refresh token family")
+		}
+
+		markerTTL, err := tx.TTL(ctx, oldKey).Result()
+		if err != nil {
+			return errors.Wrap(err, "unable to get refresh token TTL")
+		}
+		if markerTTL <= 0 {
+			markerTTL = time.Duration(newData.ExpiresIn) * time.Second
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Del(ctx, oldKey)
+			pipe.SRem(ctx, s.makeKey("access_refresh", oldAccessID), s.tokenID(oldRefreshToken))
+			// A token that does not expire is remembered as consumed forever.
+			pipe.Set(ctx, s.tokenKey("refresh_consumed", oldRefreshToken), familyID, markerTTL)
+			s.queueSaveAccess(ctx, pipe, accessID, familyID, payload, newData, nil)
+			return nil
+		})
+		return err
+	}, watched...)
+	if errors.Is(err, redis.TxFailedErr) {
+		// Another rotation consumed the token between the read and the write.
+		err = errors.Wrap(ErrNotFound, "refresh token already used")
+	}
+	if err != nil {
+		return errors.Wrap(err, "failed to rotate refresh token")
+	}
+	s.hooks.saveAccess(ctx, accessID, newData)
+	return nil
+}
+
+// AddRefreshToken registers refreshToken as another refresh token of the
+// access record accessID, as returned by SaveAccessID, e.g. one per device of
+// a single grant. It lives as long as the record. LoadRefresh resolves it to
+// the record with RefreshToken set to it, RemoveRefresh removes just that
+// token while others remain, and RemoveAccess and the revocation methods
+// remove all of them. The error wraps ErrNotFound if the record is gone, or
+// ErrTokenCollision under WithSetNX if the token exists.
+//
+// Rotating any of the tokens with RotateRefresh consumes only that token.
+func (s *Storage) AddRefreshToken(ctx context.Context, accessID, refreshToken string) (err error) {
+	ctx, done := s.begin(ctx, "AddRefreshToken")
+	defer done(&err)
+	if refreshToken == "" {
+		return ErrInvalidToken
+	}
+
+	keys := []string{
+		s.makeKey("access", accessID),
+		s.tokenKey("refresh_token", refreshToken),
+		s.makeKey("access_refresh", accessID),
+	}
+	added, err := addRefreshScript.Run(ctx, s.pool, keys, accessID, s.tokenID(refreshToken), s.setNX).Int()
+	if err != nil {
+		return keyError(keys[1], errors.Wrap(err, "failed to add refresh token"))
+	}
+	switch added {
+	case 0:
+		return keyError(keys[0], errors.Wrap(ErrNotFound, "unable to add refresh token"))
+	case -1:
+		return keyError(keys[1], errors.Wrap(ErrTokenCollision, "token pointer exists"))
+	}
+	return nil
+}
+
+// addRefreshScript points the refresh token pointer KEYS[2] at the access ID
+// ARGV[1] and adds ARGV[2] to its access_refresh set KEYS[3], both expiring
+// with the access record KEYS[1]. It returns 0 if the record does not exist
+// and, if ARGV[3] is set, -1 if the pointer does.
+var addRefreshScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then
+	return 0
+end
+if ARGV[3] == "1" and redis.call("EXISTS", KEYS[2]) == 1 then
+	return -1
+end
+if ttl > 0 then
+	redis.call("SET", KEYS[2], ARGV[1], "PX", ttl)
+else
+	redis.call("SET", KEYS[2], ARGV[1])
+end
+redis.call("SADD", KEYS[3], ARGV[2])
+if ttl > 0 then
+	redis.call("PEXPIRE", KEYS[3], ttl)
+end
+return 1
+`)
+
+// DetectRefreshReuse reports whether refreshToken has already been consumed
+// by RotateRefresh.
+func (s *Storage) DetectRefreshReuse(ctx context.Context, refreshToken string) (_ bool, err error) {
+	ctx, done := s.begin(ctx, "DetectRefreshReuse")
+	defer done(&err)
+
+	n, err := s.pool.Exists(ctx, s.tokenKey("refresh_consumed", refreshToken)).Result()
+	return n > 0, errors.Wrap(err, "unable to check refresh token reuse")
+}
+
+// RefreshFamily returns the ID of the family refreshToken belongs to, whether
+// the token is live or already consumed.
+func (s *Storage) RefreshFamily(ctx context.Context, refreshToken string) (_ string, err error) {
+	ctx, done := s.begin(ctx, "RefreshFamily")
+	defer done(&err)
+
+	familyID, err := s.pool.Get(ctx, s.tokenKey("refresh_consumed", refreshToken)).Result()
+	if err == nil {
+		return familyID, nil
+	}
+	if !errors.Is(err, redis.Nil) {
+		return "", errors.Wrap(err, "unable to get refresh token family")
+	}
+
+	accessID, err := s.getAccessID(ctx, s.tokenKey("refresh_token", refreshToken))
+	if err != nil {
+		return "", err
+	}
+	familyID, err = s.pool.Get(ctx, s.makeKey("access_family", accessID)).Result()
+	if errors.Is(err, redis.Nil) {
+		return accessID, nil
+	}
+	return familyID, errors.Wrap(err, "unable to get refresh token family")
+}
+
+// RevokeFamily removes every live access record of the family familyID, with
+// their access and refresh tokens. Consumed markers are kept, so replays of
+// the family's old refresh tokens are still detected.
+func (s *Storage) RevokeFamily(ctx context.Context, familyID string) (err error) {
+	ctx, done := s.begin(ctx, "RevokeFamily")
+	defer done(&err)
+
+	setKey := s.makeKey("family", familyID)
+	accessIDs, err := s.pool.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return errors.Wrap(err, "unable to get family members")
+	}
+
+	for _, accessID := range accessIDs {
+		access, err := s.getAccess(ctx, accessID)
+		if errors.Is(err, ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return errors.Wrap(err, "unable to load access for revocation")
+		}
+		if _, err := s.deleteAccess(ctx, s.makeKey("access", accessID), accessID, access); err != nil {
+			return err
+		}
+	}
+
+	err = s.pool.Del(ctx, setKey).Err()
+	return errors.Wrap(err, "unable to clear family")
+}
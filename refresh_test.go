@@ -0,0 +1,251 @@
+package osinredis
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newRotatedAccessData(previous *osin.AccessData, n int) *osin.AccessData {
+	accessData := newAccessData(previous.AuthorizeData)
+	accessData.AccessToken = fmt.Sprintf("rotated-access%d", n)
+	accessData.RefreshToken = fmt.Sprintf("rotated-refresh%d", n)
+	return accessData
+}
+
+func TestRotateRefresh(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := storage.Context()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	rotated := newRotatedAccessData(accessData, 1)
+	assert.NoError(t, storage.RotateRefresh(ctx, accessData.RefreshToken, rotated))
+
+	_, err := storage.LoadRefresh(accessData.RefreshToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	loadData, err := storage.LoadRefresh(rotated.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, rotated.AccessToken, loadData.AccessToken)
+
+	_, err = storage.LoadAccess(rotated.AccessToken)
+	assert.NoError(t, err)
+
+	err = storage.RotateRefresh(ctx, accessData.RefreshToken, newRotatedAccessData(accessData, 2))
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = storage.LoadAccess("rotated-access2")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRotateRefreshWithoutExpiry(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithStatelessAccess(), WithRefreshTTL(24*time.Hour))
+	ctx := storage.Context()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, pool.Persist(ctx, storage.makeKey("refresh_token", accessData.RefreshToken)).Err())
+
+	rotated := newRotatedAccessData(accessData, 1)
+	rotated.ExpiresIn = 0
+	assert.NoError(t, storage.RotateRefresh(ctx, accessData.RefreshToken, rotated))
+
+	marker := storage.makeKey("refresh_consumed", accessData.RefreshToken)
+	assert.Equal(t, time.Duration(-1), pool.TTL(ctx, marker).Val())
+	reused, err := storage.DetectRefreshReuse(ctx, accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.True(t, reused)
+}
+
+func TestRotateRefreshConcurrent(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := storage.Context()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	const rotations = 5
+	var wg sync.WaitGroup
+	errs := make([]error, rotations)
+	for i := 0; i < rotations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = storage.RotateRefresh(ctx, accessData.RefreshToken, newRotatedAccessData(accessData, i))
+		}(i)
+	}
+	wg.Wait()
+
+	succeeded := 0
+	for _, err := range errs {
+		if err == nil {
+			succeeded++
+		} else {
+			assert.ErrorIs(t, err, ErrNotFound)
+		}
+	}
+	assert.Equal(t, 1, succeeded)
+}
+
+func TestRefreshReuseRevokesFamily(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := storage.Context()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	original := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(original))
+
+	unrelated := newAccessData(newAuthorizeData(client))
+	unrelated.AccessToken = "unrelated"
+	unrelated.RefreshToken = "runrelated"
+	assert.NoError(t, storage.SaveAccess(unrelated))
+
+	first := newRotatedAccessData(original, 1)
+	assert.NoError(t, storage.RotateRefresh(ctx, original.RefreshToken, first))
+	second := newRotatedAccessData(original, 2)
+	assert.NoError(t, storage.RotateRefresh(ctx, first.RefreshToken, second))
+
+	reused, err := storage.DetectRefreshReuse(ctx, second.RefreshToken)
+	assert.NoError(t, err)
+	assert.False(t, reused)
+
+	reused, err = storage.DetectRefreshReuse(ctx, original.RefreshToken)
+	assert.NoError(t, err)
+	assert.True(t, reused)
+
+	familyID, err := storage.RefreshFamily(ctx, original.RefreshToken)
+	assert.NoError(t, err)
+	liveFamilyID, err := storage.RefreshFamily(ctx, second.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, familyID, liveFamilyID)
+
+	otherFamilyID, err := storage.RefreshFamily(ctx, unrelated.RefreshToken)
+	assert.NoError(t, err)
+	assert.NotEqual(t, familyID, otherFamilyID)
+
+	assert.NoError(t, storage.RevokeFamily(ctx, familyID))
+
+	for _, accessData := range []*osin.AccessData{original, first, second} {
+		_, err := storage.LoadAccess(accessData.AccessToken)
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	_, err = storage.LoadRefresh(second.RefreshToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = storage.LoadAccess(unrelated.AccessToken)
+	assert.NoError(t, err)
+
+	reused, err = storage.DetectRefreshReuse(ctx, first.RefreshToken)
+	assert.NoError(t, err)
+	assert.True(t, reused)
+}
+
+func TestRevokeFamilyExpiredAccessToken(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithRefreshTTL(time.Hour))
+	ctx := storage.Context()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	original := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(original))
+	rotated := newRotatedAccessData(original, 1)
+	assert.NoError(t, storage.RotateRefresh(ctx, original.RefreshToken, rotated))
+
+	// Reuse is typically detected once the access token has expired.
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("access_token", rotated.AccessToken)).Err())
+	reused, err := storage.DetectRefreshReuse(ctx, original.RefreshToken)
+	assert.NoError(t, err)
+	assert.True(t, reused)
+
+	familyID, err := storage.RefreshFamily(ctx, original.RefreshToken)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.RevokeFamily(ctx, familyID))
+	_, err = storage.LoadRefresh(rotated.RefreshToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestAddRefreshToken(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	accessID, err := storage.SaveAccessID(ctx, accessData)
+	assert.NoError(t, err)
+
+	assert.NoError(t, storage.AddRefreshToken(ctx, accessID, "phone"))
+	assert.NoError(t, storage.AddRefreshToken(ctx, accessID, "laptop"))
+	assert.ErrorIs(t, storage.AddRefreshToken(ctx, "unknown", "tablet"), ErrNotFound)
+
+	for _, token := range []string{accessData.RefreshToken, "phone", "laptop"} {
+		loaded, err := storage.LoadRefresh(token)
+		assert.NoError(t, err)
+		assert.Equal(t, accessData.AccessToken, loaded.AccessToken)
+		assert.Equal(t, token, loaded.RefreshToken)
+	}
+
+	// Removing one device's token leaves the others and the access.
+	assert.NoError(t, storage.RemoveRefresh("phone"))
+	_, err = storage.LoadRefresh("phone")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = storage.LoadRefresh("laptop")
+	assert.NoError(t, err)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	// Removing the access clears every refresh token.
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	for _, token := range []string{accessData.RefreshToken, "laptop"} {
+		_, err := storage.LoadRefresh(token)
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	keys, err := pool.Keys(ctx, "test123:*").Result()
+	assert.NoError(t, err)
+	// The family set is left to expire.
+	assert.ElementsMatch(t, []string{"test123:client:" + client.Id, "test123:client_version:" + client.Id, "test123:family:" + accessID}, keys)
+}
+
+func TestRemoveLastRefreshToken(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	accessID, err := storage.SaveAccessID(ctx, accessData)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.AddRefreshToken(ctx, accessID, "phone"))
+
+	assert.NoError(t, storage.RemoveRefresh(accessData.RefreshToken))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.RemoveRefresh("phone"))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
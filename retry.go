@@ -0,0 +1,41 @@
+package osinredis
+
+import (
+	"context"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// retry runs op, and runs it again while it fails with a transport error, up
+// to the number of attempts set with WithRetry. The delay between attempts
+// starts at the configured backoff and doubles each time; retrying stops
+// early, with op's last error, once ctx is done.
+func (s *Storage) retry(ctx context.Context, op func() error) error {
+	err := op()
+	delay := s.retryBackoff
+	for attempt := 1; attempt < s.retryAttempts && isTransient(err); attempt++ {
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+		delay *= 2
+		err = op()
+	}
+	return err
+}
+
+// isTransient reports whether err is a network failure worth retrying, as
+// opposed to a missing key, a Redis error reply or a decoding failure.
+func isTransient(err error) bool {
+	if err == nil || errors.Is(err, ErrNotFound) {
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF)
+}
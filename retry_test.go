@@ -0,0 +1,96 @@
+package osinredis
+
+import (
+	"context"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+// flakyHook fails the next n commands or pipelines with a network error.
+type flakyHook struct {
+	n     int
+	calls int
+}
+
+func (h *flakyHook) fail() error {
+	h.calls++
+	if h.n > 0 {
+		h.n--
+		return &net.OpError{Op: "read", Net: "tcp", Err: os.ErrDeadlineExceeded}
+	}
+	return nil
+}
+
+func (h *flakyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *flakyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if err := h.fail(); err != nil {
+			cmd.SetErr(err)
+			return err
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *flakyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		if err := h.fail(); err != nil {
+			for _, cmd := range cmds {
+				cmd.SetErr(err)
+			}
+			return err
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestWithRetry(t *testing.T) {
+	flushAll()
+
+	hook := &flakyHook{}
+	flaky := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	flaky.AddHook(hook)
+	defer flaky.Close()
+
+	storage := New(flaky, "test123", WithRetry(3, time.Millisecond))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	hook.n = 2
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+
+	hook.n = 3
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.Error(t, err)
+
+	// Missing keys are not retried.
+	hook.calls = 0
+	_, err = storage.GetClient("notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.Equal(t, 1, hook.calls)
+
+	// Nor are writes that are not plain SETs.
+	hook.n = 1
+	assert.Error(t, storage.SaveAccess(newAccessData(newAuthorizeData(client))))
+	hook.n = 1
+	assert.NoError(t, storage.SaveAuthorize(newAuthorizeData(client)))
+
+	// Nor is anything once the context is done.
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	hook.n, hook.calls = 1, 0
+	_, err = storage.LoadAccessContext(ctx, accessData.AccessToken)
+	assert.Error(t, err)
+	assert.Equal(t, 1, hook.calls)
+}
@@ -0,0 +1,210 @@
+package osinredis
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ScanErrorPolicy decides what ListClients, ScanClients, Reap and ReapDryRun
+// do with an entry they cannot decode, such as a corrupt or foreign value.
+type ScanErrorPolicy int
+
+const (
+	// ScanErrorStop fails the whole walk with the decode error.
+	ScanErrorStop ScanErrorPolicy = iota
+	// ScanErrorSkip leaves the entry out and reports its key in a
+	// *ScanError, returned along with the complete results.
+	ScanErrorSkip
+)
+
+// ScanError is returned under ScanErrorSkip, along with the results, when
+// entries were skipped because they could not be decoded.
+type ScanError struct {
+	Keys []string // the keys of the skipped entries
+}
+
+func (e *ScanError) Error() string {
+	return fmt.Sprintf("osinredis: skipped %d undecodable entries", len(e.Keys))
+}
+
+// skipper applies the ScanErrorPolicy to the decode errors of a walk.
+type skipper struct {
+	policy ScanErrorPolicy
+	keys   []string
+}
+
+// skip returns the decode error err of key, or records key and returns nil
+// under ScanErrorSkip.
+func (k *skipper) skip(key string, err error) error {
+	if k.policy != ScanErrorSkip {
+		return err
+	}
+	k.keys = append(k.keys, key)
+	return nil
+}
+
+// err returns the *ScanError reporting the skipped keys, if any.
+func (k *skipper) err() error {
+	if len(k.keys) == 0 {
+		return nil
+	}
+	return &ScanError{Keys: k.keys}
+}
+
+// defaultScanCount is the COUNT hint passed to SCAN by the methods that walk
+// the keyspace, unless changed with WithScanCount.
+const defaultScanCount = 100
+
+// CountAccessTokens returns the number of live access tokens.
+//
+// The count is computed by iterating the whole keyspace with SCAN, which costs
+// O(N) in the total number of keys in the database, not just those under the
+// storage prefix. It does not block Redis, but it should not be called on a hot
+// path; poll it from a metrics collector instead.
+func (s *Storage) CountAccessTokens(ctx context.Context) (_ int64, err error) {
+	ctx, done := s.begin(ctx, "CountAccessTokens")
+	defer done(&err)
+	return s.countKeys(ctx, "access_token")
+}
+
+// CountAuthorizeCodes returns the number of live authorization codes. It has
+// the same cost as CountAccessTokens.
+func (s *Storage) CountAuthorizeCodes(ctx context.Context) (_ int64, err error) {
+	ctx, done := s.begin(ctx, "CountAuthorizeCodes")
+	defer done(&err)
+	return s.countKeys(ctx, "auth")
+}
+
+// Stats counts the entries of a storage, for a health dashboard.
+type Stats struct {
+	Clients        int64
+	AccessTokens   int64 // live access tokens
+	RefreshTokens  int64 // live refresh tokens
+	AuthorizeCodes int64 // live authorization codes
+}
+
+// Stats counts the clients, tokens and authorization codes in the storage. It
+// walks the keyspace once per kind, with the COUNT hint set by WithScanCount,
+// so it costs as much as four calls to CountAccessTokens. Bound it with a
+// deadline on ctx: it stops with ctx's error once ctx is done.
+func (s *Storage) Stats(ctx context.Context) (_ Stats, err error) {
+	ctx, done := s.begin(ctx, "Stats")
+	defer done(&err)
+
+	var stats Stats
+	for _, count := range []struct {
+		namespace string
+		n         *int64
+	}{
+		{"client", &stats.Clients},
+		{"access_token", &stats.AccessTokens},
+		{"refresh_token", &stats.RefreshTokens},
+		{"auth", &stats.AuthorizeCodes},
+	} {
+		if *count.n, err = s.countKeys(ctx, count.namespace); err != nil {
+			return Stats{}, err
+		}
+	}
+	return stats, nil
+}
+
+func (s *Storage) countKeys(ctx context.Context, namespace string) (int64, error) {
+	var count int64
+	err := s.scanKeys(ctx, namespace, func(keys []string) error {
+		count += int64(len(keys))
+		return nil
+	})
+	return count, err
+}
+
+// scanKeys calls fn with every non-empty page of keys in namespace. It stops
+// with ctx's error once ctx is done, checked before each page, and issues
+// each SCAN on ctx so that the command in flight ends with it too.
+func (s *Storage) scanKeys(ctx context.Context, namespace string, fn func(keys []string) error) error {
+	client, err := s.keyspaceClient(ctx)
+	if err != nil {
+		return errors.Wrapf(err, "unable to SCAN %s", namespace)
+	}
+	var cursor uint64
+	for {
+		if err := ctx.Err(); err != nil {
+			return errors.Wrapf(err, "unable to SCAN %s", namespace)
+		}
+		keys, next, err := client.Scan(ctx, cursor, s.scanPattern(namespace), s.scanCount).Result()
+		if err != nil {
+			return errors.Wrapf(err, "unable to SCAN %s", namespace)
+		}
+		if len(keys) > 0 {
+			if err := fn(keys); err != nil {
+				return err
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
+	}
+}
+
+// keyID recovers the id a key in namespace was built from. It fails for key
+// layouts that do not embed the id verbatim.
+func (s *Storage) keyID(namespace, key string) (string, bool) {
+	parts := strings.SplitN(s.makeKey(namespace, scanPlaceholder), scanPlaceholder, 2)
+	if len(parts) != 2 || len(key) < len(parts[0])+len(parts[1]) ||
+		!strings.HasPrefix(key, parts[0]) || !strings.HasSuffix(key, parts[1]) {
+		return "", false
+	}
+	id := key[len(parts[0]) : len(key)-len(parts[1])]
+	if s.escapeIDs {
+		unescaped, err := url.PathUnescape(id)
+		return unescaped, err == nil
+	}
+	return id, true
+}
+
+// escapeID percent-encodes the bytes of id that WithKeyEscaping escapes,
+// returning id itself if there are none.
+func escapeID(id, sep string) string {
+	escape := func(c byte) bool {
+		return c == '%' || c == '{' || c == '}' || strings.IndexByte(sep, c) >= 0
+	}
+
+	i := 0
+	for i < len(id) && !escape(id[i]) {
+		i++
+	}
+	if i == len(id) {
+		return id
+	}
+
+	var b strings.Builder
+	b.WriteString(id[:i])
+	for ; i < len(id); i++ {
+		if c := id[i]; escape(c) {
+			fmt.Fprintf(&b, "%%%02X", c)
+		} else {
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// scanPlaceholder stands in for the id when building SCAN patterns; it
+// cannot appear in a key prefix or namespace and is not a glob character.
+const scanPlaceholder = "\x00"
+
+// scanPattern returns a SCAN MATCH pattern for every key in namespace, for
+// any key layout.
+func (s *Storage) scanPattern(namespace string) string {
+	return strings.Replace(escapeGlob(s.makeKey(namespace, scanPlaceholder)), scanPlaceholder, "*", 1)
+}
+
+var globEscaper = strings.NewReplacer(`\`, `\\`, `*`, `\*`, `?`, `\?`, `[`, `\[`, `]`, `\]`)
+
+func escapeGlob(s string) string {
+	return globEscaper.Replace(s)
+}
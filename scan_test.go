@@ -0,0 +1,680 @@
+package osinredis
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountAccessTokens(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	count, err := storage.CountAccessTokens(ctx)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 0, count)
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 3)
+
+	count, err = storage.CountAccessTokens(ctx)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 3, count)
+
+	assert.NoError(t, storage.RemoveAccess(saved[0].AccessToken))
+	count, err = storage.CountAccessTokens(ctx)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, count)
+}
+
+func TestStats(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 3)
+	saved[0].RefreshToken = ""
+	assert.NoError(t, storage.RemoveRefresh("clientID-refresh0"))
+	assert.NoError(t, storage.SaveAccess(saved[0]))
+	assert.NoError(t, storage.SaveAuthorize(newAuthorizeData(client)))
+
+	stats, err := storage.Stats(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, Stats{Clients: 1, AccessTokens: 3, RefreshTokens: 2, AuthorizeCodes: 1}, stats)
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = storage.Stats(cancelled)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestCountAuthorizeCodes(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	authorizeData := newAuthorizeData(newClient())
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	assert.NoError(t, New(pool, "other").SaveAuthorize(authorizeData))
+
+	count, err := storage.CountAuthorizeCodes(ctx)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
+
+func TestScanPatternEscapesPrefix(t *testing.T) {
+	storage := New(pool, "te*st[1]")
+	assert.Equal(t, `te\*st\[1\]:client:*`, storage.scanPattern("client"))
+}
+
+func TestKeyLayout(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithKeySeparator("/"))
+	assert.Equal(t, "test123/client/clientID", storage.makeKey("client", "clientID"))
+
+	tagged := New(pool, "test123", WithKeySeparator("/"), WithKeyFunc(func(prefix, namespace, id string) string {
+		return prefix + ":" + namespace + ":{" + id + "}"
+	}))
+	assert.Equal(t, "test123:client:{clientID}", tagged.makeKey("client", "clientID"))
+	assert.Equal(t, `test123:client:{*}`, tagged.scanPattern("client"))
+
+	ctx := context.Background()
+	client := newClient()
+	assert.NoError(t, tagged.CreateClient(client))
+	assert.EqualValues(t, 1, pool.Exists(ctx, "test123:client:{clientID}").Val())
+
+	clients, err := tagged.ListClients(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, tagged.SaveAccess(accessData))
+	loadData, err := tagged.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+
+	count, err := tagged.CountAccessTokens(ctx)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+}
+
+// crc16 is the CRC16-CCITT (XMODEM) checksum Redis Cluster uses for slots.
+func crc16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc ^= uint16(data[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
+
+func clusterSlot(key string) uint16 {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return crc16(key) % 16384
+}
+
+func TestClusterHashTags(t *testing.T) {
+	flushAll()
+
+	assert.EqualValues(t, 12739, clusterSlot("123456789"))
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithClusterHashTags(), WithScopeIndex(), WithTagIndex(),
+		WithUserExtractor(func(userData interface{}) (string, bool) {
+			user, ok := userData.(string)
+			return user, ok
+		}))
+	assert.Equal(t, "{test123}:access_token:8888", storage.makeKey("access_token", "8888"))
+	slot := clusterSlot(storage.makeKey("client", "clientID"))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	accessData := newAccessData(authorizeData)
+	accessData.Scope = "read write"
+	accessData.UserData = "alice"
+	_, err := storage.SaveAccessWithTags(ctx, accessData, map[string]string{"device": "phone"})
+	assert.NoError(t, err)
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+
+	keys, err := pool.Keys(ctx, "*").Result()
+	assert.NoError(t, err)
+	assert.NotEmpty(t, keys)
+	for _, key := range keys {
+		assert.Equal(t, slot, clusterSlot(key), key)
+	}
+
+	clients, err := storage.ListClients(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+	count, err := storage.CountAccessTokens(ctx)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	keys, err = pool.Keys(ctx, "{test123}:access*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// Each tenant has a slot of its own.
+	tenant := storage.ForTenant("a")
+	assert.NotEqual(t, slot, clusterSlot(tenant.makeKey("client", "clientID")))
+
+	cluster := redis.NewClusterClient(&redis.ClusterOptions{Addrs: []string{pool.Options().Addr}})
+	defer cluster.Close()
+	assert.NotNil(t, New(cluster, "test123", WithClusterHashTags()))
+}
+
+func TestReap(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	live := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(live))
+
+	orphan := newAccessData(newAuthorizeData(client))
+	orphan.AccessToken, orphan.RefreshToken = "orphan", "rorphan"
+	assert.NoError(t, storage.SaveAccess(orphan))
+	assert.NoError(t, pool.Del(ctx, "test123:access_token:orphan", "test123:refresh_token:rorphan").Err())
+
+	assert.NoError(t, pool.Set(ctx, "test123:access_token:dangling", "gone", 0).Err())
+	assert.NoError(t, pool.Set(ctx, "test123:refresh_token:rdangling", "gone", 0).Err())
+
+	liveID, err := pool.Get(ctx, "test123:access_token:"+live.AccessToken).Result()
+	assert.NoError(t, err)
+	keys, err := storage.ReapDryRun(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, keys, 5)
+	assert.Contains(t, keys, "test123:access_token:dangling")
+	assert.Contains(t, keys, "test123:refresh_token:rdangling")
+	assert.NotContains(t, keys, "test123:access:"+liveID)
+
+	stats, err := storage.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ReapStats{AccessTokens: 1, RefreshTokens: 1, Records: 1}, stats)
+
+	_, err = storage.LoadAccess(live.AccessToken)
+	assert.NoError(t, err)
+	accessKeys, err := pool.Keys(ctx, "test123:access:*").Result()
+	assert.NoError(t, err)
+	assert.Len(t, accessKeys, 1)
+	members, err := pool.SMembers(ctx, "test123:client_tokens:"+client.GetId()).Result()
+	assert.NoError(t, err)
+	assert.Len(t, members, 1)
+
+	stats, err = storage.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ReapStats{}, stats)
+	keys, err = storage.ReapDryRun(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestReapKeepsAddedRefreshTokens(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessID, err := storage.SaveAccessID(ctx, accessData)
+	assert.NoError(t, err)
+	assert.NoError(t, storage.AddRefreshToken(ctx, accessID, "device2"))
+	assert.NoError(t, storage.RemoveRefresh(accessData.RefreshToken))
+	// The access token expires before the refresh tokens.
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("access_token", accessData.AccessToken)).Err())
+
+	keys, err := storage.ReapDryRun(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	stats, err := storage.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ReapStats{}, stats)
+	_, err = storage.LoadRefresh("device2")
+	assert.NoError(t, err)
+
+	// With its last refresh token gone the record is an orphan.
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("refresh_token", "device2")).Err())
+	stats, err = storage.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ReapStats{Records: 1}, stats)
+}
+
+func TestReapStatelessAccess(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithStatelessAccess())
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("refresh_token", accessData.RefreshToken)).Err())
+
+	// The record has no pointer left but is live until it expires.
+	keys, err := storage.ReapDryRun(ctx)
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	stats, err := storage.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ReapStats{}, stats)
+	revoked, err := storage.RevokeAllForClient(ctx, client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+
+	// Without an expiry it is an orphan.
+	accessID, err := storage.SaveAccessID(ctx, accessData)
+	assert.NoError(t, err)
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("refresh_token", accessData.RefreshToken)).Err())
+	assert.NoError(t, pool.Persist(ctx, storage.makeKey("access", accessID)).Err())
+	keys, err = storage.ReapDryRun(ctx)
+	assert.NoError(t, err)
+	assert.Contains(t, keys, storage.makeKey("access", accessID))
+	stats, err = storage.Reap(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, ReapStats{Records: 1}, stats)
+}
+
+func TestForTenant(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	root := initTestStorage()
+	tenantA := root.ForTenant("a")
+	tenantB := root.ForTenant("b")
+
+	client := newClient()
+	assert.NoError(t, tenantA.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, tenantA.SaveAccess(accessData))
+
+	exists, err := pool.Exists(ctx, "test123:tenant:a:access_token:"+accessData.AccessToken).Result()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, exists)
+
+	_, err = tenantA.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	for _, storage := range []*Storage{root, tenantB} {
+		_, err = storage.LoadAccess(accessData.AccessToken)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		clients, err := storage.ListClients(ctx)
+		assert.NoError(t, err)
+		assert.Empty(t, clients)
+
+		count, err := storage.CountAccessTokens(ctx)
+		assert.NoError(t, err)
+		assert.Zero(t, count)
+
+		revoked, err := storage.RevokeAllForClient(ctx, client.GetId())
+		assert.NoError(t, err)
+		assert.Zero(t, revoked)
+	}
+
+	clients, err := tenantA.ListClients(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+
+	revoked, err := tenantA.RevokeAllForClient(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+}
+
+func TestWithNamespaces(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	ns := DefaultNamespaces()
+	ns.AccessToken = "ac"
+	ns.Client = "cl"
+	storage := New(pool, "test123", WithNamespaces(ns))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	for _, key := range []string{"test123:ac:" + accessData.AccessToken, "test123:cl:" + client.GetId()} {
+		exists, err := pool.Exists(ctx, key).Result()
+		assert.NoError(t, err)
+		assert.EqualValues(t, 1, exists, key)
+	}
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	_, err = initTestStorage().LoadAccess(accessData.AccessToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	count, err := storage.CountAccessTokens(ctx)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count)
+	clients, err := storage.ListClients(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, clients, 1)
+}
+
+// endlessScanHook records the COUNT of every SCAN and reports a non-zero
+// cursor after each one, so that a scan only ends when it is aborted.
+type endlessScanHook struct {
+	counts []interface{}
+}
+
+func (h *endlessScanHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *endlessScanHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		err := next(ctx, cmd)
+		if scan, ok := cmd.(*redis.ScanCmd); ok && err == nil {
+			args := scan.Args()
+			h.counts = append(h.counts, args[len(args)-1])
+			keys, _ := scan.Val()
+			scan.SetVal(keys, 1)
+		}
+		return err
+	}
+}
+
+func (h *endlessScanHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// blockingScanHook holds every SCAN until the context it was issued on is
+// done and counts them. It checks that the caller's ctx reaches SCAN, not
+// how go-redis reacts to a server that does not answer.
+type blockingScanHook struct {
+	started chan struct{}
+	scans   int32
+}
+
+func (h *blockingScanHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *blockingScanHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if _, ok := cmd.(*redis.ScanCmd); !ok {
+			return next(ctx, cmd)
+		}
+		if atomic.AddInt32(&h.scans, 1) == 1 {
+			close(h.started)
+		}
+		<-ctx.Done()
+		cmd.SetErr(ctx.Err())
+		return ctx.Err()
+	}
+}
+
+func (h *blockingScanHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+// TestScanUsesCallerContext checks that the SCAN in flight is issued on the
+// caller's ctx and that no further SCAN is issued once ctx is done.
+func TestScanUsesCallerContext(t *testing.T) {
+	flushAll()
+
+	hook := &blockingScanHook{}
+	blocking := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	blocking.AddHook(hook)
+	defer blocking.Close()
+	storage := New(blocking, "test123")
+	assert.NoError(t, storage.CreateClient(newClient()))
+
+	for _, scan := range []struct {
+		name string
+		run  func(ctx context.Context) error
+	}{
+		{"ListClients", func(ctx context.Context) error {
+			_, err := storage.ListClients(ctx)
+			return err
+		}},
+		{"CountAccessTokens", func(ctx context.Context) error {
+			_, err := storage.CountAccessTokens(ctx)
+			return err
+		}},
+		{"Reap", func(ctx context.Context) error {
+			_, err := storage.Reap(ctx)
+			return err
+		}},
+	} {
+		t.Run(scan.name, func(t *testing.T) {
+			hook.started = make(chan struct{})
+			atomic.StoreInt32(&hook.scans, 0)
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			result := make(chan error, 1)
+			go func() { result <- scan.run(ctx) }()
+
+			<-hook.started
+			start := time.Now()
+			cancel()
+			select {
+			case err := <-result:
+				assert.ErrorIs(t, err, context.Canceled)
+			case <-time.After(time.Second):
+				t.Fatal("scan not cancelled")
+			}
+			assert.Less(t, time.Since(start), time.Second)
+			assert.EqualValues(t, 1, atomic.LoadInt32(&hook.scans))
+		})
+	}
+}
+
+func TestWithScanCount(t *testing.T) {
+	flushAll()
+
+	hook := &endlessScanHook{}
+	endless := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	endless.AddHook(hook)
+	defer endless.Close()
+
+	storage := New(endless, "test123", WithScanCount(10))
+	assert.NoError(t, storage.CreateClient(newClient()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	pages := 0
+	err := storage.scanKeys(ctx, "client", func(keys []string) error {
+		pages++
+		cancel()
+		return nil
+	})
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, 1, pages)
+	assert.Equal(t, []interface{}{int64(10)}, hook.counts)
+
+	hook.counts = nil
+	ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = storage.ListClients(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.NotEmpty(t, hook.counts)
+	assert.Equal(t, int64(10), hook.counts[0])
+}
+
+func TestWithKeyEscaping(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithKeyEscaping())
+	assert.Equal(t, "test123:client:a%3Ab%25c%7Bd%7D", storage.makeKey("client", "a:b%c{d}"))
+	assert.Equal(t, "test123:client:clientID", storage.makeKey("client", "clientID"))
+	assert.Equal(t, "test123:client:*", storage.scanPattern("client"))
+
+	ctx := context.Background()
+	client := newClient()
+	client.Id = "a:b"
+	assert.NoError(t, storage.CreateClient(client))
+	assert.EqualValues(t, 1, pool.Exists(ctx, "test123:client:a%3Ab").Val())
+
+	found, err := storage.GetClient("a:b")
+	assert.NoError(t, err)
+	assert.Equal(t, client, found)
+
+	clients, err := storage.ListClients(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, []osin.Client{client}, clients)
+
+	// Export records the decoded id, so it imports into the plain layout.
+	var buf bytes.Buffer
+	assert.NoError(t, storage.Export(ctx, &buf))
+	flushAll()
+	plain := New(pool, "test123")
+	assert.NoError(t, plain.Import(ctx, &buf))
+	found, err = plain.GetClient("a:b")
+	assert.NoError(t, err)
+	assert.Equal(t, client, found)
+}
+
+func TestWithScanErrorPolicy(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	strict := initTestStorage()
+	client := newClient()
+	assert.NoError(t, strict.CreateClient(client))
+	assert.NoError(t, pool.Set(ctx, "test123:client:corrupt", "not a gob", 0).Err())
+	assert.NoError(t, pool.Set(ctx, "test123:access:corrupt", "not a gob", 0).Err())
+
+	_, err := strict.ListClients(ctx)
+	assert.Error(t, err)
+	var scanErr *ScanError
+	assert.False(t, errors.As(err, &scanErr))
+	_, err = strict.Reap(ctx)
+	assert.Error(t, err)
+
+	lenient := New(pool, "test123", WithScanErrorPolicy(ScanErrorSkip))
+	clients, err := lenient.ListClients(ctx)
+	assert.Equal(t, []osin.Client{client}, clients)
+	assert.True(t, errors.As(err, &scanErr))
+	assert.Equal(t, []string{"test123:client:corrupt"}, scanErr.Keys)
+
+	_, err = lenient.Reap(ctx)
+	assert.True(t, errors.As(err, &scanErr))
+	assert.Equal(t, []string{"test123:access:corrupt"}, scanErr.Keys)
+	assert.EqualValues(t, 1, pool.Exists(ctx, "test123:access:corrupt").Val())
+}
+
+// blackholeProxy forwards connections to the test server until a client
+// sends SCAN, then drops everything that client sends, leaving the SCAN
+// unanswered on a real connection.
+type blackholeProxy struct {
+	net.Listener
+	scanned chan struct{}
+	once    sync.Once
+}
+
+func newBlackholeProxy(t *testing.T) *blackholeProxy {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &blackholeProxy{Listener: l, scanned: make(chan struct{})}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go p.serve(conn)
+		}
+	}()
+	return p
+}
+
+func (p *blackholeProxy) serve(conn net.Conn) {
+	defer conn.Close()
+	upstream, err := net.Dial("tcp", pool.Options().Addr)
+	if err != nil {
+		return
+	}
+	defer upstream.Close()
+	go io.Copy(conn, upstream)
+
+	buf := make([]byte, 4096)
+	blackholed := false
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return
+		}
+		if !blackholed && bytes.Contains(bytes.ToUpper(buf[:n]), []byte("SCAN")) {
+			blackholed = true
+			p.once.Do(func() { close(p.scanned) })
+		}
+		if !blackholed {
+			if _, err := upstream.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// TestScanDeadlineUnansweredServer checks that with ContextTimeoutEnabled a
+// SCAN the server never answers returns at ctx's deadline, not ReadTimeout.
+func TestScanDeadlineUnansweredServer(t *testing.T) {
+	flushAll()
+	assert.NoError(t, initTestStorage().CreateClient(newClient()))
+
+	proxy := newBlackholeProxy(t)
+	defer proxy.Close()
+	client := redis.NewClient(&redis.Options{
+		Addr:                  proxy.Addr().String(),
+		ReadTimeout:           time.Minute,
+		ContextTimeoutEnabled: true,
+	})
+	defer client.Close()
+	storage := New(client, "test123")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := storage.ListClients(ctx)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Less(t, time.Since(start), 5*time.Second)
+
+	select {
+	case <-proxy.scanned:
+	default:
+		t.Fatal("SCAN never reached the server")
+	}
+}
@@ -0,0 +1,79 @@
+package osinredis
+
+import (
+	"context"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// ListTokensByScope returns the live access tokens granting scope, which
+// requires WithScopeIndex. Entries whose access record has expired are
+// dropped from the index along the way.
+func (s *Storage) ListTokensByScope(ctx context.Context, scope string) (_ []string, err error) {
+	ctx, done := s.begin(ctx, "ListTokensByScope")
+	defer done(&err)
+	if s.statelessAccess {
+		return nil, ErrStateless
+	}
+
+	return s.indexedTokens(ctx, s.makeKey("scope", scope))
+}
+
+// indexedTokens returns the live access tokens of the access IDs in the index
+// set setKey, removing those whose access record has expired from it.
+func (s *Storage) indexedTokens(ctx context.Context, setKey string) ([]string, error) {
+	accessIDs, err := s.pool.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to get indexed tokens")
+	}
+	if len(accessIDs) == 0 {
+		return []string{}, nil
+	}
+
+	keys := make([]string, len(accessIDs))
+	for i, accessID := range accessIDs {
+		keys[i] = s.makeKey("access", accessID)
+	}
+	values, err := s.pool.MGet(ctx, keys...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to MGET access")
+	}
+
+	var (
+		stale  []interface{}
+		tokens []string
+		live   []*redis.IntCmd
+	)
+	pipe := s.pool.Pipeline()
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			stale = append(stale, accessIDs[i])
+			continue
+		}
+		var access osin.AccessData
+		if err := s.decode([]byte(raw), &access); err != nil {
+			return nil, errors.Wrap(err, "failed to decode access gob")
+		}
+		// The record outlives its access token when it has a refresh
+		// token, so the token itself has to be checked.
+		tokens = append(tokens, access.AccessToken)
+		live = append(live, pipe.Exists(ctx, s.tokenKey("access_token", access.AccessToken)))
+	}
+	if len(stale) > 0 {
+		pipe.SRem(ctx, setKey, stale...)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.Wrap(err, "unable to check indexed tokens")
+	}
+
+	active := make([]string, 0, len(tokens))
+	for i, token := range tokens {
+		if live[i].Val() > 0 {
+			active = append(active, token)
+		}
+	}
+	return active, nil
+}
@@ -0,0 +1,52 @@
+package osinredis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestListTokensByScope(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithScopeIndex())
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 3)
+	saved[0].Scope = "read write"
+	saved[1].Scope = "read"
+	saved[2].Scope = "admin"
+	for _, accessData := range saved {
+		assert.NoError(t, storage.SaveAccess(accessData))
+	}
+
+	tokens, err := storage.ListTokensByScope(ctx, "read")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{saved[0].AccessToken, saved[1].AccessToken}, tokens)
+
+	tokens, err = storage.ListTokensByScope(ctx, "write")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{saved[0].AccessToken}, tokens)
+
+	assert.NoError(t, storage.RemoveAccess(saved[0].AccessToken))
+	tokens, err = storage.ListTokensByScope(ctx, "read")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{saved[1].AccessToken}, tokens)
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("scope", "write")).Val())
+
+	// An expired record is dropped from the index.
+	accessID, err := pool.Get(ctx, storage.makeKey("access_token", saved[1].AccessToken)).Result()
+	assert.NoError(t, err)
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("access", accessID)).Err())
+	tokens, err = storage.ListTokensByScope(ctx, "read")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+	assert.EqualValues(t, 0, pool.SCard(ctx, storage.makeKey("scope", "read")).Val())
+
+	tokens, err = storage.ListTokensByScope(ctx, "unknown")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+}
@@ -0,0 +1,106 @@
+package osinredis
+
+import (
+	"bytes"
+	"encoding/gob"
+	"reflect"
+	"sync"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+)
+
+// DefaultGobTypes returns the types a GobSerializer without Types registers
+// with encoding/gob: the osin types, and the generic containers user data is
+// commonly built from, so that nested values round-trip without registration
+// by the caller.
+func DefaultGobTypes() []interface{} {
+	return []interface{}{
+		map[string]interface{}{},
+		[]interface{}{},
+		map[string]string{},
+		&osin.DefaultClient{},
+		osin.AuthorizeData{},
+		osin.AccessData{},
+	}
+}
+
+var (
+	// defaultGobTypes registers DefaultGobTypes once.
+	defaultGobTypes sync.Once
+
+	// registeredGobTypes holds the types registerGobTypes has registered.
+	registeredGobTypes sync.Map
+)
+
+// registerGobTypes registers each of types with encoding/gob the first time
+// it is seen. A type another package already registered under a different
+// name keeps that name.
+func registerGobTypes(types []interface{}) {
+	for _, v := range types {
+		if _, seen := registeredGobTypes.LoadOrStore(reflect.TypeOf(v), struct{}{}); !seen {
+			registerGobType(v)
+		}
+	}
+}
+
+func registerGobType(v interface{}) {
+	defer func() {
+		// gob.Register panics on a type registered under another name.
+		recover()
+	}()
+	gob.Register(v)
+}
+
+// RegisterUserData registers the concrete type of v with encoding/gob, so
+// that GobSerializer can round-trip it when it is stored in an interface field
+// such as osin.AccessData.UserData. It must be called before the first value
+// holding that type is encoded or decoded, typically from an init function.
+// Like the types of a GobSerializer, a type already registered under another
+// name keeps that name.
+func RegisterUserData(v interface{}) {
+	registerGobTypes([]interface{}{v})
+}
+
+// Serializer converts the values kept by Storage to and from the bytes stored
+// in Redis.
+type Serializer interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// GobSerializer is the default Serializer, backed by encoding/gob.
+//
+// The types stored in interface fields have to be registered with
+// encoding/gob, whose registry is global. A GobSerializer registers its Types,
+// or DefaultGobTypes if Types is nil, when it is first used rather than when
+// the package is loaded. Types replaces the defaults; append to
+// DefaultGobTypes() to keep them.
+type GobSerializer struct {
+	Types []interface{}
+}
+
+func (g GobSerializer) register() {
+	if g.Types == nil {
+		defaultGobTypes.Do(func() { registerGobTypes(DefaultGobTypes()) })
+		return
+	}
+	registerGobTypes(g.Types)
+}
+
+// Marshal encodes v as a gob.
+func (g GobSerializer) Marshal(v interface{}) ([]byte, error) {
+	g.register()
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, errors.Wrap(err, "unable to encode")
+	}
+	return buf.Bytes(), nil
+}
+
+// Unmarshal decodes the gob in data into v.
+func (g GobSerializer) Unmarshal(data []byte, v interface{}) error {
+	g.register()
+	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
+	return errors.Wrap(err, "unable to decode")
+}
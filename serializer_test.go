@@ -0,0 +1,160 @@
+package osinredis
+
+import (
+	"encoding/gob"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/stretchr/testify/assert"
+)
+
+type countingSerializer struct {
+	GobSerializer
+	marshals, unmarshals int
+}
+
+func (c *countingSerializer) Marshal(v interface{}) ([]byte, error) {
+	c.marshals++
+	return c.GobSerializer.Marshal(v)
+}
+
+func (c *countingSerializer) Unmarshal(data []byte, v interface{}) error {
+	c.unmarshals++
+	return c.GobSerializer.Unmarshal(data, v)
+}
+
+func TestWithSerializer(t *testing.T) {
+	flushAll()
+
+	serializer := &countingSerializer{}
+	storage := New(pool, "test123", WithSerializer(serializer))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+
+	assert.Equal(t, 1, serializer.marshals)
+	assert.Equal(t, 1, serializer.unmarshals)
+}
+
+type sessionInfo struct {
+	UserID string
+	Scopes []string
+}
+
+func init() {
+	RegisterUserData(&sessionInfo{})
+}
+
+func TestRegisterUserData(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.UserData = &sessionInfo{UserID: "user1", Scopes: []string{"read"}}
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.UserData, loadData.UserData)
+}
+
+type renamedClient struct {
+	osin.DefaultClient
+}
+
+func TestRegisterRenamedType(t *testing.T) {
+	gob.RegisterName("osinredis.renamed", &renamedClient{})
+
+	assert.NotPanics(t, func() { RegisterUserData(&renamedClient{}) })
+	assert.NotPanics(t, func() {
+		New(pool, "test123", WithClientFactory(func() osin.Client { return &renamedClient{} }))
+	})
+}
+
+func TestAuthorizeDataRoundTrip(t *testing.T) {
+	client := newClient()
+
+	tests := []struct {
+		name     string
+		userData interface{}
+		// JSON decodes user data as plain JSON values, so only those
+		// round-trip through it.
+		gobOnly bool
+	}{
+		{name: "none"},
+		{name: "string", userData: "alice"},
+		{name: "map", userData: map[string]interface{}{"sub": "alice", "admin": true}},
+		{name: "nested", userData: map[string]interface{}{"roles": []interface{}{"admin", map[string]interface{}{"team": "core"}}}},
+		{name: "slice", userData: []interface{}{"a", 1.5, true}},
+		{name: "string map", userData: map[string]string{"env": "prod"}, gobOnly: true},
+		{name: "registered", userData: &sessionInfo{UserID: "user1", Scopes: []string{"read"}}, gobOnly: true},
+	}
+	serializers := map[string]Serializer{
+		"gob":  GobSerializer{},
+		"json": NewJSONSerializer(),
+	}
+
+	for serializerName, serializer := range serializers {
+		for _, test := range tests {
+			if test.gobOnly && serializerName != "gob" {
+				continue
+			}
+			t.Run(serializerName+"/"+test.name, func(t *testing.T) {
+				flushAll()
+
+				storage := New(pool, "test123", WithSerializer(serializer))
+				assert.NoError(t, storage.CreateClient(client))
+
+				authorizeData := &osin.AuthorizeData{
+					Client:              client,
+					Code:                "8888",
+					ExpiresIn:           3600,
+					Scope:               "read write",
+					RedirectUri:         "http://localhost/callback",
+					State:               "xyz",
+					CreatedAt:           time.Now().UTC().Round(0),
+					UserData:            test.userData,
+					CodeChallenge:       "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM",
+					CodeChallengeMethod: "S256",
+				}
+				assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+				loaded, err := storage.LoadAuthorize(authorizeData.Code)
+				assert.NoError(t, err)
+				assert.Equal(t, authorizeData, loaded)
+			})
+		}
+	}
+}
+
+type gobTypesTestData struct {
+	Tenant string
+}
+
+func TestGobSerializerTypes(t *testing.T) {
+	flushAll()
+
+	serializer := GobSerializer{Types: append(DefaultGobTypes(), gobTypesTestData{})}
+	storage := New(pool, "test123", WithSerializer(serializer))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.UserData = gobTypesTestData{Tenant: "acme"}
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loaded, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.UserData, loaded.UserData)
+
+	// Registering a type twice, or one registered elsewhere, is harmless.
+	registerGobTypes([]interface{}{gobTypesTestData{}, &sessionInfo{}})
+}
@@ -1,37 +1,164 @@
 package osinredis
 
 import (
-	"bytes"
 	"context"
-	"encoding/gob"
-	"fmt"
+	"crypto/cipher"
+	"strings"
 	"time"
 
 	"github.com/RangelReale/osin"
 	"github.com/pkg/errors"
 	"github.com/redis/go-redis/v9"
-	uuid "github.com/satori/go.uuid"
 )
 
-func init() {
-	gob.Register(map[string]interface{}{})
-	gob.Register(&osin.DefaultClient{})
-	gob.Register(osin.AuthorizeData{})
-	gob.Register(osin.AccessData{})
-}
-
 // Storage implements "github.com/RangelReale/osin".Storage
+//
+// A Storage is safe for concurrent use by multiple goroutines. Its
+// configuration is fixed by the options passed to New and cannot be changed
+// afterwards; the copies returned by WithContext and ForTenant share it.
 type Storage struct {
-	pool      *redis.Client
+	pool      redis.UniversalClient
 	keyPrefix string
+	ctx       context.Context
+
+	// ownsPool is set when the storage created pool or was handed it with
+	// WithOwnedClient, so Close closes it.
+	ownsPool bool
+
+	config
+}
+
+// config holds the settings applied by the options. It is only written by
+// New, before the storage is returned.
+type config struct {
+	serializer Serializer
+	clientTTL  time.Duration
+	observer   Observer
+	tracer     Tracer
+	logger     Logger
+	compress   bool
+	encrypted  bool
+	checksum   bool
+	refreshTTL time.Duration
+
+	keySeparator string
+	namespaces   Namespaces
+	keyFunc      func(prefix, namespace, id string) string
+	escapeIDs    bool
+
+	clusterHashTags bool
+	clock           Clock
+	idGenerator     IDGenerator
+
+	usernameExtractor func(*osin.AccessData) string
+	embeddedClient    bool
+	newClient         func() osin.Client
+	defaultTimeout    time.Duration
+	maxPayloadSize    int
+	lenientTTL        bool
+	readPool          *redis.Client
+	scanCount         int64
+	hooks             Hooks
+	scopeIndex        bool
+	tagIndex          bool
+	userExtractor     func(userData interface{}) (string, bool)
+	setNX             bool
+	statelessAccess   bool
+	revokeConcurrency int
+	scanErrorPolicy   ScanErrorPolicy
+	retryAttempts     int
+	retryBackoff      time.Duration
+	minAuthorizeTTL   time.Duration
+	migrateTTL        time.Duration
+	tokenHash         func(token string) string
+	accessMeta        []byte
+	clientCache       *clientCache
+	ownedClient       bool
+
+	aead    cipher.AEAD
+	aeadErr error
 }
 
 // New initializes and returns a new Storage
-func New(pool *redis.Client, keyPrefix string) *Storage {
+func New(pool redis.UniversalClient, keyPrefix string, opts ...Option) *Storage {
+	c := config{
+		serializer:        GobSerializer{},
+		keySeparator:      ":",
+		logger:            nopLogger{},
+		clock:             systemClock{},
+		idGenerator:       uuidGenerator{},
+		namespaces:        DefaultNamespaces(),
+		scanCount:         defaultScanCount,
+		migrateTTL:        defaultMigrateTTL,
+		revokeConcurrency: 1,
+	}
+	for _, opt := range opts {
+		opt(&c)
+	}
 	return &Storage{
 		pool:      pool,
 		keyPrefix: keyPrefix,
+		ownsPool:  c.ownedClient,
+		config:    c,
+	}
+}
+
+// NewFromOptions creates a Redis client from opts, including its database
+// and ACL credentials, and returns a Storage that owns it: Close closes the
+// client. It fails if Redis cannot be reached.
+func NewFromOptions(redisOpts *redis.Options, keyPrefix string, opts ...Option) (*Storage, error) {
+	if redisOpts == nil {
+		return nil, errors.New("nil redis options")
+	}
+	pool := redis.NewClient(redisOpts)
+	if err := pool.Ping(context.Background()).Err(); err != nil {
+		pool.Close()
+		return nil, errors.Wrap(err, "unable to PING")
 	}
+	s := New(pool, keyPrefix, opts...)
+	s.ownsPool = true
+	return s, nil
+}
+
+// WithContext returns a shallow copy of the storage that uses ctx for the
+// methods of the osin.Storage interface, which do not take a context, as in
+// storage.WithContext(r.Context()).LoadAccess(token). The copy shares the
+// storage's client and configuration.
+func (s *Storage) WithContext(ctx context.Context) *Storage {
+	if ctx == nil {
+		panic("nil context")
+	}
+	clone := s.borrow()
+	clone.ctx = ctx
+	return clone
+}
+
+// ForTenant returns a shallow copy of the storage whose keys all live under
+// the tenant, as in "prefix:tenant:<tenantID>:access:...", so that every
+// method, including the SCAN-based ones, only sees that tenant's data.
+// tenantID should not contain the key separator.
+func (s *Storage) ForTenant(tenantID string) *Storage {
+	clone := s.borrow()
+	clone.keyPrefix = s.keyPrefix + s.keySeparator + "tenant" + s.keySeparator + tenantID
+	return clone
+}
+
+// Context returns the storage's context. To change the context, use WithContext.
+func (s *Storage) Context() context.Context {
+	if s.ctx != nil {
+		return s.ctx
+	}
+	return context.Background()
+}
+
+// operationContext returns the context for a call to a method of the
+// osin.Storage interface: the context set with WithContext, or else a
+// background context bounded by WithDefaultTimeout.
+func (s *Storage) operationContext() (context.Context, context.CancelFunc) {
+	if s.ctx == nil && s.defaultTimeout > 0 {
+		return context.WithTimeout(context.Background(), s.defaultTimeout)
+	}
+	return s.Context(), func() {}
 }
 
 // Clone the storage if needed. For example, using mgo, you can clone the session with session.Clone
@@ -39,220 +166,1038 @@ func New(pool *redis.Client, keyPrefix string) *Storage {
 // This is to avoid cloning the connection at each method access.
 // Can return itself if not a problem.
 func (s *Storage) Clone() osin.Storage {
-	return s
+	if !s.ownsPool {
+		return s
+	}
+	return s.borrow()
 }
 
-// Close the resources the Storage potentially holds (using Clone for example)
-func (s *Storage) Close() {}
+// reader returns the storage to run load operations on, which uses the
+// client set with WithReadClient, if any.
+func (s *Storage) reader() *Storage {
+	if s.readPool == nil {
+		return s
+	}
+	clone := s.borrow()
+	clone.pool = s.readPool
+	return clone
+}
+
+// borrow returns a shallow copy of the storage that shares its client
+// without owning it, so closing the copy leaves the client open.
+func (s *Storage) borrow() *Storage {
+	clone := *s
+	clone.ownsPool = false
+	return &clone
+}
+
+// Close the resources the Storage potentially holds (using Clone for example).
+// The Redis client is closed only if the storage created it; copies made with
+// Clone, WithContext or ForTenant never close it.
+func (s *Storage) Close() {
+	if !s.ownsPool {
+		return
+	}
+	if err := s.pool.Close(); err != nil {
+		s.logger.Errorf("osinredis: unable to close redis client: %v", err)
+	}
+}
+
+// Ping checks that Redis is reachable. It gives up once ctx is done.
+func (s *Storage) Ping(ctx context.Context) (err error) {
+	ctx, done := s.begin(ctx, "Ping")
+	defer done(&err)
+	return errors.Wrap(s.pool.Ping(ctx).Err(), "unable to PING")
+}
 
 // CreateClient inserts a new client
 func (s *Storage) CreateClient(client osin.Client) error {
-	ctx := context.Background()
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.CreateClientContext(ctx, client)
+}
 
-	payload, err := encode(client)
+// CreateClientContext inserts a new client
+func (s *Storage) CreateClientContext(ctx context.Context, client osin.Client) (err error) {
+	ctx, done := s.begin(ctx, "CreateClient")
+	defer done(&err)
+	return s.putClient(ctx, client)
+}
+
+func (s *Storage) putClient(ctx context.Context, client osin.Client) error {
+	if client.GetId() == "" {
+		return ErrInvalidClientID
+	}
+	payload, err := s.encode(client)
 	if err != nil {
 		return errors.Wrap(err, "failed to encode client")
 	}
 
-	return s.pool.Set(ctx, s.makeKey("client", client.GetId()), payload, 0).Err()
+	key := s.makeKey("client", client.GetId())
+	err = putClientScript.Run(ctx, s.pool, s.clientKeys(client.GetId()), payload, s.clientTTL.Milliseconds(), "").Err()
+	if s.clientCache != nil {
+		s.clientCache.invalidate(key)
+	}
+	return keyError(key, err)
 }
 
 // GetClient gets a client by ID
 func (s *Storage) GetClient(id string) (osin.Client, error) {
-	ctx := context.Background()
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.GetClientContext(ctx, id)
+}
 
-	rawClientGob, err := s.pool.Get(ctx, s.makeKey("client", id)).Bytes()
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to GET client")
+// GetClientContext gets a client by ID
+func (s *Storage) GetClientContext(ctx context.Context, id string) (_ osin.Client, err error) {
+	ctx, done := s.begin(ctx, "GetClient")
+	defer done(&err)
+	var client osin.Client
+	err = s.retry(ctx, func() (err error) {
+		client, err = s.reader().getClient(ctx, id)
+		return err
+	})
+	return client, err
+}
+
+func (s *Storage) getClient(ctx context.Context, id string) (osin.Client, error) {
+	key := s.makeKey("client", id)
+	if s.clientCache != nil {
+		if rawClientGob, ok := s.clientCache.get(key, s.clock.Now()); ok {
+			return s.decodeClient(rawClientGob)
+		}
+	}
+
+	rawClientGob, err := s.pool.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) || (err == nil && len(rawClientGob) == 0) {
+		return nil, keyError(key, errors.Wrap(ErrNotFound, "unable to GET client"))
 	}
-	if len(rawClientGob) == 0 {
-		return nil, nil
+	if err != nil {
+		return nil, keyError(key, errors.Wrap(err, "unable to GET client"))
 	}
 
-	var client osin.DefaultClient
-	err = decode(rawClientGob, &client)
-	return &client, errors.Wrap(err, "failed to decode client gob")
+	if s.clientCache != nil {
+		s.clientCache.put(key, rawClientGob, s.clock.Now())
+	}
+	client, err := s.decodeClient(rawClientGob)
+	return client, keyError(key, err)
 }
 
 // UpdateClient updates a client
 func (s *Storage) UpdateClient(client osin.Client) error {
-	return errors.Wrap(s.CreateClient(client), "failed to update client")
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.UpdateClientContext(ctx, client)
+}
+
+// UpdateClientContext updates a client
+func (s *Storage) UpdateClientContext(ctx context.Context, client osin.Client) (err error) {
+	ctx, done := s.begin(ctx, "UpdateClient")
+	defer done(&err)
+	return errors.Wrap(s.putClient(ctx, client), "failed to update client")
 }
 
 // DeleteClient deletes given client
 func (s *Storage) DeleteClient(client osin.Client) error {
-	ctx := context.Background()
-	return s.pool.Del(ctx, s.makeKey("client", client.GetId())).Err()
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.DeleteClientContext(ctx, client)
+}
+
+// DeleteClientContext deletes given client. Its version is bumped and kept,
+// so that a version read before the deletion does not match a client later
+// stored under the same ID.
+func (s *Storage) DeleteClientContext(ctx context.Context, client osin.Client) (err error) {
+	ctx, done := s.begin(ctx, "DeleteClient")
+	defer done(&err)
+	key := s.makeKey("client", client.GetId())
+	pipe := s.pool.TxPipeline()
+	pipe.Del(ctx, key, s.makeKey("client_disabled", client.GetId()), s.makeKey("client_meta", client.GetId()))
+	pipe.Incr(ctx, s.makeKey("client_version", client.GetId()))
+	_, err = pipe.Exec(ctx)
+	if s.clientCache != nil {
+		s.clientCache.invalidate(key)
+	}
+	return err
 }
 
 // SaveAuthorize saves authorize data.
-func (s *Storage) SaveAuthorize(data *osin.AuthorizeData) (err error) {
-	ctx := context.Background()
+func (s *Storage) SaveAuthorize(data *osin.AuthorizeData) error {
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.SaveAuthorizeContext(ctx, data)
+}
 
-	payload, err := encode(data)
+// SaveAuthorizeContext saves authorize data.
+func (s *Storage) SaveAuthorizeContext(ctx context.Context, data *osin.AuthorizeData) (err error) {
+	ctx, done := s.begin(ctx, "SaveAuthorize")
+	defer done(&err)
+	if data.Code == "" {
+		return ErrInvalidToken
+	}
+	saved := data
+	if minTTL := int32((s.minAuthorizeTTL + time.Second - 1) / time.Second); data.ExpiresIn < minTTL {
+		clamped := *data
+		clamped.ExpiresIn = minTTL
+		saved = &clamped
+	}
+	if saved.ExpiresIn <= 0 {
+		return errors.Wrapf(ErrInvalidExpiry, "authorize data expires in %d seconds", data.ExpiresIn)
+	}
+	payload, err := s.encode(saved)
 	if err != nil {
 		return errors.Wrap(err, "failed to encode data")
 	}
 
-	return s.pool.SetEx(ctx, s.makeKey("auth", data.Code), string(payload), time.Duration(data.ExpiresIn)*time.Second).Err()
+	key := s.makeKey("auth", data.Code)
+	err = s.retry(ctx, func() error {
+		return s.pool.SetEx(ctx, key, string(payload), time.Duration(saved.ExpiresIn)*time.Second).Err()
+	})
+	if err != nil {
+		return keyError(key, err)
+	}
+	s.hooks.saveAuthorize(ctx, data)
+	return nil
 }
 
 // LoadAuthorize looks up AuthorizeData by a code.
 // Client information MUST be loaded together.
 // Optionally can return error if expired.
 func (s *Storage) LoadAuthorize(code string) (*osin.AuthorizeData, error) {
-	ctx := context.Background()
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.LoadAuthorizeContext(ctx, code)
+}
 
-	rawClientGob, err := s.pool.Get(ctx, s.makeKey("auth", code)).Bytes()
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to GET auth")
+// LoadAuthorizeContext looks up AuthorizeData by a code.
+func (s *Storage) LoadAuthorizeContext(ctx context.Context, code string) (_ *osin.AuthorizeData, err error) {
+	ctx, done := s.begin(ctx, "LoadAuthorize")
+	defer done(&err)
+	key := s.makeKey("auth", code)
+	var auth *osin.AuthorizeData
+	err = s.retry(ctx, func() error {
+		pipe := s.reader().pool.Pipeline()
+		get := pipe.Get(ctx, key)
+		revoked := pipe.Exists(ctx, s.makeKey("auth_revoked", code))
+		pipe.Exec(ctx)
+
+		rawClientGob, err := get.Bytes()
+		auth, err = s.decodeAuthorize(key, rawClientGob, err, revoked.Val() > 0)
+		return err
+	})
+	return auth, err
+}
+
+// decodeAuthorize decodes the result of reading the authorization code at
+// key, which was revoked if it is missing and has a tombstone.
+func (s *Storage) decodeAuthorize(key string, rawClientGob []byte, err error, revoked bool) (*osin.AuthorizeData, error) {
+	if errors.Is(err, redis.Nil) || (err == nil && len(rawClientGob) == 0) {
+		if revoked {
+			return nil, keyError(key, errors.Wrap(ErrRevoked, "unable to GET auth"))
+		}
+		return nil, keyError(key, errors.Wrap(ErrNotFound, "unable to GET auth"))
 	}
-	if len(rawClientGob) == 0 {
-		return nil, nil
+	if err != nil {
+		return nil, keyError(key, errors.Wrap(err, "unable to GET auth"))
 	}
 
 	var auth osin.AuthorizeData
-	err = decode(rawClientGob, &auth)
-	return &auth, errors.Wrap(err, "failed to decode auth")
+	err = s.decode(rawClientGob, &auth)
+	return &auth, keyError(key, errors.Wrap(err, "failed to decode auth"))
+}
+
+// ConsumeAuthorize loads and removes the authorization code in one atomic
+// step, so that a code can be exchanged at most once even by concurrent
+// requests. Like RemoveAuthorize it leaves a tombstone, so a code that was
+// already consumed fails with ErrRevoked, which matches ErrNotFound.
+func (s *Storage) ConsumeAuthorize(ctx context.Context, code string) (_ *osin.AuthorizeData, err error) {
+	ctx, done := s.begin(ctx, "ConsumeAuthorize")
+	defer done(&err)
+	keys := []string{s.makeKey("auth", code), s.makeKey("auth_revoked", code)}
+	rawClientGob, err := consumeAuthorizeScript.Run(ctx, s.pool, keys, revokedCodeTTL.Milliseconds()).Text()
+	if errors.Is(err, redis.Nil) {
+		return s.consumedAuthorize(ctx, keys)
+	}
+	if err != nil {
+		return s.decodeAuthorize(keys[0], nil, err, false)
+	}
+	s.hooks.removeAuthorize(ctx, code)
+	return s.decodeAuthorize(keys[0], []byte(rawClientGob), nil, false)
+}
+
+// ConsumeAuthorizeWithPKCE is ConsumeAuthorize for a code exchanged with the
+// PKCE code verifier verifier. The code is only consumed if verifier matches
+// its code challenge, as checked by ValidatePKCE; on a mismatch it fails with
+// ErrPKCEMismatch and leaves the code in place, so that a bad verifier does not
+// waste a valid code. The code is consumed only if it is unchanged since it
+// was verified, so it is still exchanged at most once.
+func (s *Storage) ConsumeAuthorizeWithPKCE(ctx context.Context, code, verifier string) (_ *osin.AuthorizeData, err error) {
+	ctx, done := s.begin(ctx, "ConsumeAuthorizeWithPKCE")
+	defer done(&err)
+	keys := []string{s.makeKey("auth", code), s.makeKey("auth_revoked", code)}
+	raw, err := s.pool.Get(ctx, keys[0]).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return s.consumedAuthorize(ctx, keys)
+	}
+	data, err := s.decodeAuthorize(keys[0], raw, err, false)
+	if err != nil {
+		return nil, err
+	}
+	if err := ValidatePKCE(data, verifier); err != nil {
+		return nil, keyError(keys[0], err)
+	}
+
+	consumed, err := consumeAuthorizeIfScript.Run(ctx, s.pool, keys, revokedCodeTTL.Milliseconds(), raw).Int()
+	if err != nil {
+		return nil, keyError(keys[0], errors.Wrap(err, "unable to consume auth"))
+	}
+	if consumed == 0 {
+		return s.consumedAuthorize(ctx, keys)
+	}
+	s.hooks.removeAuthorize(ctx, code)
+	return data, nil
+}
+
+// consumedAuthorize returns the error of consuming the authorization code
+// keys[0] that no longer exists: ErrRevoked if its tombstone keys[1] does,
+// ErrNotFound otherwise.
+func (s *Storage) consumedAuthorize(ctx context.Context, keys []string) (*osin.AuthorizeData, error) {
+	revoked, err := s.pool.Exists(ctx, keys[1]).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to check auth tombstone")
+	}
+	return s.decodeAuthorize(keys[0], nil, redis.Nil, revoked > 0)
 }
 
 // RemoveAuthorize revokes or deletes the authorization code.
-func (s *Storage) RemoveAuthorize(code string) (err error) {
-	ctx := context.Background()
+func (s *Storage) RemoveAuthorize(code string) error {
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.RemoveAuthorizeContext(ctx, code)
+}
 
-	return s.pool.Del(ctx, s.makeKey("auth", code)).Err()
+// RemoveAuthorizeContext revokes or deletes the authorization code.
+func (s *Storage) RemoveAuthorizeContext(ctx context.Context, code string) (err error) {
+	ctx, done := s.begin(ctx, "RemoveAuthorize")
+	defer done(&err)
+	keys := []string{s.makeKey("auth", code), s.makeKey("auth_revoked", code)}
+	removed, err := removeAuthorizeScript.Run(ctx, s.pool, keys, revokedCodeTTL.Milliseconds()).Int()
+	if err != nil {
+		return err
+	}
+	if removed > 0 {
+		s.hooks.removeAuthorize(ctx, code)
+	}
+	return nil
 }
 
+// revokedCodeTTL bounds the life of the tombstone of a removed authorization
+// code that had no expiry.
+const revokedCodeTTL = 10 * time.Minute
+
+// consumeAuthorizeScript is removeAuthorizeScript returning the code's value,
+// or nil if it did not exist.
+var consumeAuthorizeScript = redis.NewScript(`
+local value = redis.call("GET", KEYS[1])
+if not value then
+	return false
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -1 then
+	ttl = ARGV[1]
+end
+redis.call("SET", KEYS[2], "1", "PX", ttl)
+redis.call("DEL", KEYS[1])
+return value
+`)
+
+// consumeAuthorizeIfScript is removeAuthorizeScript for a code whose value is
+// still ARGV[2], returning 0 without removing it otherwise.
+var consumeAuthorizeIfScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[2] then
+	return 0
+end
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -1 then
+	ttl = ARGV[1]
+end
+redis.call("SET", KEYS[2], "1", "PX", ttl)
+return redis.call("DEL", KEYS[1])
+`)
+
+// removeAuthorizeScript deletes the authorization code KEYS[1] and, if it
+// existed, leaves the tombstone KEYS[2] for as long as the code would have
+// lived, or ARGV[1] milliseconds if it had no expiry.
+var removeAuthorizeScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -2 then
+	return 0
+end
+if ttl == -1 then
+	ttl = ARGV[1]
+end
+redis.call("SET", KEYS[2], "1", "PX", ttl)
+return redis.call("DEL", KEYS[1])
+`)
+
 // SaveAccess creates AccessData.
-func (s *Storage) SaveAccess(data *osin.AccessData) (err error) {
-	ctx := context.Background()
+func (s *Storage) SaveAccess(data *osin.AccessData) error {
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.SaveAccessContext(ctx, data)
+}
+
+// SaveAccessContext creates AccessData.
+func (s *Storage) SaveAccessContext(ctx context.Context, data *osin.AccessData) error {
+	_, err := s.SaveAccessID(ctx, data)
+	return err
+}
+
+// SaveAccessID creates AccessData and returns the ID of its access record,
+// which is also the ID of the refresh token family it starts.
+func (s *Storage) SaveAccessID(ctx context.Context, data *osin.AccessData) (_ string, err error) {
+	ctx, done := s.begin(ctx, "SaveAccess")
+	defer done(&err)
+	return s.saveAccess(ctx, data, nil)
+}
+
+// saveAccess stores data along with tags, as SaveAccessID and
+// SaveAccessWithTags do.
+func (s *Storage) saveAccess(ctx context.Context, data *osin.AccessData, tags map[string]string) (string, error) {
+	if data.AccessToken == "" {
+		return "", ErrInvalidToken
+	}
+	payload, err := s.encode(data)
+	if err != nil {
+		return "", errors.Wrap(err, "failed to encode access")
+	}
 
-	payload, err := encode(data)
+	accessID, err := s.idGenerator.NewID()
 	if err != nil {
-		return errors.Wrap(err, "failed to encode access")
+		return "", errors.Wrap(err, "failed to generate access ID")
 	}
 
-	accessID := uuid.NewV4().String()
+	// The access record and its lookup pointers are written in a single
+	// MULTI/EXEC so a failure never leaves one without the others.
+	if s.setNX {
+		err = s.saveAccessNX(ctx, accessID, payload, data, tags)
+	} else {
+		pipe := s.pool.TxPipeline()
+		s.queueSaveAccess(ctx, pipe, accessID, accessID, payload, data, tags)
+		_, err = pipe.Exec(ctx)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "failed to save access")
+	}
+	s.hooks.saveAccess(ctx, accessID, data)
+	return accessID, nil
+}
 
-	if err := s.pool.SetEx(ctx, s.makeKey("access", accessID), string(payload), time.Duration(data.ExpiresIn)).Err(); err != nil {
-		return errors.Wrap(err, "failed to save access")
+// saveAccessNX runs the MULTI/EXEC of SaveAccess under WATCH of the token
+// pointers, so that it fails with ErrTokenCollision rather than overwrite one
+// that exists or is written concurrently.
+func (s *Storage) saveAccessNX(ctx context.Context, accessID string, payload []byte, data *osin.AccessData, tags map[string]string) error {
+	keys := s.pointerKeys(data)
+	if len(keys) == 0 {
+		// A stateless access token without a refresh token has no pointer
+		// to collide with, nor anything else to write.
+		pipe := s.pool.TxPipeline()
+		s.queueSaveAccess(ctx, pipe, accessID, accessID, payload, data, tags)
+		_, err := pipe.Exec(ctx)
+		return err
 	}
+	err := s.pool.Watch(ctx, func(tx *redis.Tx) error {
+		if err := checkCollision(ctx, tx, keys); err != nil {
+			return err
+		}
+		_, err := tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			s.queueSaveAccess(ctx, pipe, accessID, accessID, payload, data, tags)
+			return nil
+		})
+		return err
+	}, keys...)
+	if errors.Is(err, redis.TxFailedErr) {
+		return errors.Wrap(ErrTokenCollision, "token pointer written concurrently")
+	}
+	return err
+}
 
-	if err := s.pool.SetEx(ctx, s.makeKey("access_token", data.AccessToken), accessID, time.Duration(data.ExpiresIn)).Err(); err != nil {
-		return errors.Wrap(err, "failed to register access token")
+// pointerKeys returns the keys of the lookup pointers SaveAccess writes for
+// data.
+func (s *Storage) pointerKeys(data *osin.AccessData) []string {
+	var keys []string
+	if !s.statelessAccess {
+		keys = append(keys, s.tokenKey("access_token", data.AccessToken))
+	}
+	if data.RefreshToken != "" {
+		keys = append(keys, s.tokenKey("refresh_token", data.RefreshToken))
 	}
+	return keys
+}
 
-	err = s.pool.SetEx(ctx, s.makeKey("refresh_token", data.AccessToken), accessID, time.Duration(data.ExpiresIn)).Err()
-	return errors.Wrap(err, "failed to register refresh token")
+// checkCollision fails with ErrTokenCollision if one of the pointer keys
+// exists.
+func checkCollision(ctx context.Context, c redis.Cmdable, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	n, err := c.Exists(ctx, keys...).Result()
+	if err != nil {
+		return errors.Wrap(err, "unable to check token pointers")
+	}
+	if n > 0 {
+		return errors.Wrap(ErrTokenCollision, "token pointer exists")
+	}
+	return nil
+}
+
+// queueSaveAccess queues the writes storing the encoded access data under
+// accessID as a member of the refresh token family familyID, along with its
+// lookup pointers and index entries.
+func (s *Storage) queueSaveAccess(ctx context.Context, pipe redis.Pipeliner, accessID, familyID string, payload []byte, data *osin.AccessData, tags map[string]string) {
+	if s.statelessAccess && data.RefreshToken == "" {
+		// Nothing is ever looked up through the record.
+		return
+	}
+	accessTTL := time.Duration(data.ExpiresIn) * time.Second
+
+	// The record has to live as long as the longest-lived token pointing
+	// at it.
+	recordTTL := accessTTL
+	if data.RefreshToken != "" && s.refreshTTL > recordTTL {
+		recordTTL = s.refreshTTL
+	}
+
+	pipe.SetEx(ctx, s.makeKey("access", accessID), string(payload), recordTTL)
+	pipe.SetEx(ctx, s.makeKey("access_family", accessID), familyID, recordTTL)
+	if s.accessMeta != nil {
+		pipe.SetEx(ctx, s.makeKey("access_meta", accessID), s.accessMeta, recordTTL)
+	}
+	pipe.SAdd(ctx, s.makeKey("family", familyID), accessID)
+	pipe.Expire(ctx, s.makeKey("family", familyID), recordTTL)
+	if !s.statelessAccess {
+		pipe.SetEx(ctx, s.tokenKey("access_token", data.AccessToken), accessID, accessTTL)
+	}
+	if data.RefreshToken != "" {
+		pipe.SetEx(ctx, s.tokenKey("refresh_token", data.RefreshToken), accessID, recordTTL)
+		pipe.SAdd(ctx, s.makeKey("access_refresh", accessID), s.tokenID(data.RefreshToken))
+		pipe.Expire(ctx, s.makeKey("access_refresh", accessID), recordTTL)
+	}
+	if data.Client != nil {
+		pipe.SAdd(ctx, s.makeKey("client_tokens", data.Client.GetId()), accessID)
+	}
+	if s.scopeIndex {
+		for _, scope := range strings.Fields(data.Scope) {
+			pipe.SAdd(ctx, s.makeKey("scope", scope), accessID)
+		}
+	}
+	if userID, ok := s.userID(data); ok {
+		pipe.SAdd(ctx, s.makeKey("user_tokens", userID), accessID)
+	}
+	if len(tags) > 0 {
+		pipe.HSet(ctx, s.makeKey("access_tags", accessID), tags)
+		pipe.Expire(ctx, s.makeKey("access_tags", accessID), recordTTL)
+		if s.tagIndex {
+			for key, value := range tags {
+				pipe.SAdd(ctx, s.makeKey("tag", tagID(key, value)), accessID)
+			}
+		}
+	}
+}
+
+// indexSets returns the keys of the index sets SaveAccess adds the access ID
+// of access to.
+func (s *Storage) indexSets(access *osin.AccessData) []string {
+	var sets []string
+	if access.Client != nil {
+		sets = append(sets, s.makeKey("client_tokens", access.Client.GetId()))
+	}
+	if userID, ok := s.userID(access); ok {
+		sets = append(sets, s.makeKey("user_tokens", userID))
+	}
+	if s.scopeIndex {
+		for _, scope := range strings.Fields(access.Scope) {
+			sets = append(sets, s.makeKey("scope", scope))
+		}
+	}
+	return sets
+}
+
+// userID returns the user the function set with WithUserExtractor finds in
+// the UserData of data, if any.
+func (s *Storage) userID(data *osin.AccessData) (string, bool) {
+	if s.userExtractor == nil {
+		return "", false
+	}
+	return s.userExtractor(data.UserData)
 }
 
 // LoadAccess gets access data with given access token
 func (s *Storage) LoadAccess(token string) (*osin.AccessData, error) {
-	return s.loadAccessByKey(s.makeKey("access_token", token))
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.LoadAccessContext(ctx, token)
+}
+
+// LoadAccessContext gets access data with given access token
+func (s *Storage) LoadAccessContext(ctx context.Context, token string) (_ *osin.AccessData, err error) {
+	ctx, done := s.begin(ctx, "LoadAccess")
+	defer done(&err)
+	if s.statelessAccess {
+		return nil, ErrStateless
+	}
+	var access *osin.AccessData
+	err = s.retry(ctx, func() (err error) {
+		access, err = s.reader().loadAccessByKey(ctx, "access_token", s.tokenKey("access_token", token))
+		return err
+	})
+	return access, err
+}
+
+// LoadAccessWithTTL gets access data with given access token along with the
+// remaining life of the token. Unlike LoadAccess, which overwrites ExpiresIn
+// with the remaining life, it returns ExpiresIn as saved. The duration is
+// negative if the token does not expire.
+func (s *Storage) LoadAccessWithTTL(ctx context.Context, token string) (_ *osin.AccessData, _ time.Duration, err error) {
+	ctx, done := s.begin(ctx, "LoadAccessWithTTL")
+	defer done(&err)
+	if s.statelessAccess {
+		return nil, 0, ErrStateless
+	}
+	var (
+		access *osin.AccessData
+		ttl    time.Duration
+	)
+	err = s.retry(ctx, func() (err error) {
+		access, _, ttl, err = s.reader().loadAccessWithTTL(ctx, "access_token", s.tokenKey("access_token", token))
+		return err
+	})
+	return access, ttl, err
+}
+
+// LoadAccessLite gets access data with given access token like LoadAccess,
+// but skips loading its clients, for checks that only need the token to be
+// valid. access.Client, and the client of its AuthorizeData, are minimal
+// stubs carrying nothing but the client ID.
+func (s *Storage) LoadAccessLite(ctx context.Context, token string) (_ *osin.AccessData, err error) {
+	ctx, done := s.begin(ctx, "LoadAccessLite")
+	defer done(&err)
+	if s.statelessAccess {
+		return nil, ErrStateless
+	}
+	var (
+		access *osin.AccessData
+		ttl    time.Duration
+	)
+	err = s.retry(ctx, func() (err error) {
+		access, _, ttl, err = s.reader().loadAccessRecord(ctx, "access_token", s.tokenKey("access_token", token))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	stubClients(access)
+	setExpiresIn(access, ttl)
+	return access, nil
 }
 
-// RemoveAccess deletes AccessData with given access token
+// RemoveAccess deletes AccessData with given access token. Removing a token
+// that does not exist succeeds.
 func (s *Storage) RemoveAccess(token string) error {
-	return s.removeAccessByKey(s.makeKey("access_token", token))
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.RemoveAccessContext(ctx, token)
 }
 
-// LoadRefresh gets access data with given refresh token
+// RemoveAccessContext deletes AccessData with given access token
+func (s *Storage) RemoveAccessContext(ctx context.Context, token string) (err error) {
+	ctx, done := s.begin(ctx, "RemoveAccess")
+	defer done(&err)
+	return ignoreNotFound(s.removeAccessByKey(ctx, s.tokenKey("access_token", token)))
+}
+
+// LoadRefresh gets access data with given refresh token. Its ExpiresIn is the
+// remaining life of the refresh token.
 func (s *Storage) LoadRefresh(token string) (*osin.AccessData, error) {
-	return s.loadAccessByKey(s.makeKey("refresh_token", token))
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.LoadRefreshContext(ctx, token)
 }
 
-// RemoveRefresh deletes AccessData with given refresh token
+// LoadRefreshContext gets access data with given refresh token
+func (s *Storage) LoadRefreshContext(ctx context.Context, token string) (_ *osin.AccessData, err error) {
+	ctx, done := s.begin(ctx, "LoadRefresh")
+	defer done(&err)
+	var access *osin.AccessData
+	err = s.retry(ctx, func() (err error) {
+		access, err = s.reader().loadAccessByKey(ctx, "refresh_token", s.tokenKey("refresh_token", token))
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	// The token may be one added with AddRefreshToken.
+	access.RefreshToken = token
+	return access, nil
+}
+
+// RemoveRefresh deletes AccessData with given refresh token. Removing a token
+// that does not exist succeeds. If other refresh tokens were added to the
+// access with AddRefreshToken, only this one is removed; the access goes with
+// the last of them.
 func (s *Storage) RemoveRefresh(token string) error {
-	return s.removeAccessByKey(s.makeKey("refresh_token", token))
+	ctx, cancel := s.operationContext()
+	defer cancel()
+	return s.RemoveRefreshContext(ctx, token)
 }
 
-func (s *Storage) removeAccessByKey(key string) error {
-	ctx := context.Background()
+// RemoveRefreshContext deletes AccessData with given refresh token
+func (s *Storage) RemoveRefreshContext(ctx context.Context, token string) (err error) {
+	ctx, done := s.begin(ctx, "RemoveRefresh")
+	defer done(&err)
 
-	accessID, err := s.pool.Get(ctx, key).Result()
+	key := s.tokenKey("refresh_token", token)
+	accessID, err := s.getAccessID(ctx, key)
 	if err != nil {
-		return errors.Wrap(err, "failed to get access")
+		return ignoreNotFound(err)
+	}
+	keys := []string{key, s.makeKey("access_refresh", accessID)}
+	removed, err := removeRefreshScript.Run(ctx, s.pool, keys, accessID, s.tokenID(token)).Int()
+	if err != nil {
+		return keyError(key, errors.Wrap(err, "failed to remove refresh token"))
+	}
+	if removed > 0 {
+		return nil
 	}
+	return ignoreNotFound(s.removeAccessByKey(ctx, key))
+}
+
+// removeRefreshScript deletes the refresh token pointer KEYS[1] and removes
+// ARGV[2] from the access_refresh set KEYS[2] of the access ID ARGV[1], but
+// only while the pointer still resolves to it and the set holds other
+// refresh tokens. It returns 0 otherwise, for the whole access to be removed.
+var removeRefreshScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) ~= ARGV[1] or redis.call("SISMEMBER", KEYS[2], ARGV[2]) == 0 or
+	redis.call("SCARD", KEYS[2]) < 2 then
+	return 0
+end
+redis.call("SREM", KEYS[2], ARGV[2])
+return redis.call("DEL", KEYS[1])
+`)
+
+// ignoreNotFound drops an ErrNotFound error: revoking a token that is unknown
+// or already gone succeeds, as RFC 7009 requires.
+func ignoreNotFound(err error) error {
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	return err
+}
 
-	access, err := s.loadAccessByKey(key)
+// removeAccessScript deletes an access record and its lookup pointers, but
+// only while the pointer in KEYS[1] still resolves to the access ID in
+// ARGV[1] or, if ARGV[3] is 1, while KEYS[1], the record itself, exists. The
+// next ARGV[2] keys are index sets the access ID is removed from; the
+// remaining keys are deleted. The token values are read from the encoded
+// record on the client side, since the script cannot decode it.
+var removeAccessScript = redis.NewScript(`
+if ARGV[3] == "1" then
+	if redis.call("EXISTS", KEYS[1]) == 0 then
+		return 0
+	end
+elseif redis.call("GET", KEYS[1]) ~= ARGV[1] then
+	return 0
+end
+local sets = tonumber(ARGV[2])
+for i = 2, sets + 1 do
+	redis.call("SREM", KEYS[i], ARGV[1])
+end
+return redis.call("DEL", unpack(KEYS, sets + 2))
+`)
+
+func (s *Storage) removeAccessByKey(ctx context.Context, key string) error {
+	accessID, err := s.getAccessID(ctx, key)
 	if err != nil {
+		return errors.Wrap(err, "failed to get access")
+	}
+
+	access, err := s.getAccess(ctx, accessID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
 		return errors.Wrap(err, "unable to load access for removal")
 	}
 
-	if access == nil {
-		return nil
+	_, err = s.deleteAccess(ctx, key, accessID, access)
+	return err
+}
+
+// deleteAccess atomically deletes the access record accessID together with its
+// pointers and index memberships, provided guardKey, a token pointer, still
+// resolves to it or guardKey is the record and still exists. access may be
+// nil if the record is already gone, in which case only guardKey is cleaned
+// up. It reports whether anything was deleted.
+func (s *Storage) deleteAccess(ctx context.Context, guardKey, accessID string, access *osin.AccessData) (bool, error) {
+	refreshIDs, err := s.pool.SMembers(ctx, s.makeKey("access_refresh", accessID)).Result()
+	if err != nil {
+		return false, errors.Wrap(err, "unable to get refresh tokens of access")
+	}
+	keys, sets := s.removeAccessKeys(guardKey, accessID, access, refreshIDs)
+	deleted, err := removeAccessScript.Run(ctx, s.pool, keys, s.removeAccessArgs(guardKey, accessID, sets)...).Int()
+	if err != nil {
+		return false, errors.Wrap(err, "failed to delete access")
+	}
+	if deleted > 0 {
+		s.hooks.removeAccess(ctx, accessID, access)
 	}
+	return deleted > 0, nil
+}
 
-	accessKey := s.makeKey("access", accessID)
+// removeAccessArgs returns the arguments of removeAccessScript for the keys
+// removeAccessKeys returned with sets index sets. Removals starting from the
+// access ID, rather than a token, are guarded by the record itself, since
+// its access token pointer may have expired while its refresh tokens live.
+func (s *Storage) removeAccessArgs(guardKey, accessID string, sets int) []interface{} {
+	recordGuard := 0
+	if guardKey == s.makeKey("access", accessID) {
+		recordGuard = 1
+	}
+	return []interface{}{accessID, sets, recordGuard}
+}
 
-	if err := s.pool.Del(ctx, accessKey).Err(); err != nil {
-		return errors.Wrap(err, "failed to delete access")
+// removeAccessKeys returns the keys and number of index sets to pass to
+// removeAccessScript. refreshIDs are the members of the access_refresh set of
+// the record.
+func (s *Storage) removeAccessKeys(guardKey, accessID string, access *osin.AccessData, refreshIDs []string) ([]string, int) {
+	var sets []string
+	if access != nil {
+		sets = s.indexSets(access)
 	}
 
-	accessTokenKey := s.makeKey("access_token", access.AccessToken)
-	if err := s.pool.Del(ctx, accessTokenKey).Err(); err != nil {
-		return errors.Wrap(err, "failed to deregister access_token")
+	keys := append([]string{guardKey}, sets...)
+	keys = append(keys, guardKey, s.makeKey("access", accessID), s.makeKey("access_family", accessID), s.makeKey("access_meta", accessID),
+		s.makeKey("access_refresh", accessID), s.makeKey("access_tags", accessID))
+	keys = append(keys, s.refreshPointerKeys(refreshIDs)...)
+	if access != nil {
+		keys = append(keys, s.tokenKey("access_token", access.AccessToken))
+		if access.RefreshToken != "" {
+			keys = append(keys, s.tokenKey("refresh_token", access.RefreshToken))
+		}
 	}
+	return keys, len(sets)
+}
 
-	refreshTokenKey := s.makeKey("refresh_token", access.RefreshToken)
-	err = s.pool.Del(ctx, refreshTokenKey).Err()
-	return errors.Wrap(err, "failed to deregister refresh_token")
+// loadAccessByKey loads the access record the pointer key in namespace points
+// to, with ExpiresIn set to the remaining life of that pointer's token.
+func (s *Storage) loadAccessByKey(ctx context.Context, namespace, key string) (*osin.AccessData, error) {
+	access, _, ttl, err := s.loadAccessWithTTL(ctx, namespace, key)
+	if err != nil {
+		return nil, err
+	}
+	setExpiresIn(access, ttl)
+	return access, nil
 }
 
-func (s *Storage) loadAccessByKey(key string) (*osin.AccessData, error) {
-	ctx := context.Background()
+// setExpiresIn sets the ExpiresIn of access to the remaining life ttl of one
+// of its tokens; a token without expiry keeps the stored value.
+func setExpiresIn(access *osin.AccessData, ttl time.Duration) {
+	if ttl >= 0 {
+		access.ExpiresIn = int32(ttl.Seconds())
+	}
+}
 
-	accessID, err := s.pool.Get(ctx, key).Result()
+// loadAccessWithTTL loads the access record the pointer key in namespace
+// points to, with its clients, its ID and the remaining life of its token of
+// that namespace: the refresh token's for "refresh_token", else the access
+// token's. The access token's is shorter than the record's when
+// WithRefreshTTL is in use, and zero once it has expired while its refresh
+// token is still valid. Either is negative if the token never expires or,
+// with WithLenientTTL, its TTL could not be read.
+func (s *Storage) loadAccessWithTTL(ctx context.Context, namespace, key string) (*osin.AccessData, string, time.Duration, error) {
+	access, accessID, ttl, err := s.loadAccessRecord(ctx, namespace, key)
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to get access ID")
+		return nil, "", 0, err
+	}
+	if err := s.hydrateClients(ctx, access); err != nil {
+		return nil, "", 0, err
 	}
+	return access, accessID, ttl, nil
+}
+
+// loadAccessRecord is loadAccessWithTTL without hydrating the clients.
+func (s *Storage) loadAccessRecord(ctx context.Context, namespace, key string) (*osin.AccessData, string, time.Duration, error) {
+	// The pointer's TTL is read along with it, which saves a round trip
+	// when it is the TTL wanted.
+	pipe := s.pool.Pipeline()
+	pointer := pipe.Get(ctx, key)
+	pointerTTL := pipe.TTL(ctx, key)
+	pipe.Exec(ctx)
 
-	accessIDKey := s.makeKey("access", accessID)
-	accessGob, err := s.pool.Get(ctx, accessIDKey).Bytes()
+	accessID, err := pointer.Result()
+	if errors.Is(err, redis.Nil) {
+		return nil, "", 0, keyError(key, errors.Wrap(ErrNotFound, "unable to get access ID"))
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to get access gob")
+		return nil, "", 0, keyError(key, errors.Wrap(err, "unable to get access ID"))
 	}
 
-	var access osin.AccessData
-	if err := decode(accessGob, &access); err != nil {
-		return nil, errors.Wrap(err, "failed to decode access gob")
+	access, err := s.getAccess(ctx, accessID)
+	if err != nil {
+		return nil, "", 0, err
 	}
 
-	ttl, err := s.pool.TTL(ctx, accessIDKey).Result()
+	ttl, err := pointerTTL.Result()
+	if namespace != "refresh_token" {
+		if accessKey := s.tokenKey("access_token", access.AccessToken); accessKey != key {
+			ttl, err = s.pool.TTL(ctx, accessKey).Result()
+		}
+	}
+	if err != nil && s.lenientTTL {
+		s.logger.Errorf("osinredis: unable to get %s TTL, keeping stored expiry: %v", namespace, err)
+		ttl, err = -1, nil
+	}
 	if err != nil {
-		return nil, errors.Wrap(err, "unable to get access TTL")
+		return nil, "", 0, errors.Wrapf(err, "unable to get %s TTL", namespace)
 	}
 
-	access.ExpiresIn = int32(ttl)
+	// TTL reports -1 for a key without expiry and -2 for a missing key.
+	if ttl == -2 {
+		ttl = 0
+	}
+	return access, accessID, ttl, nil
+}
 
-	access.Client, err = s.GetClient(access.Client.GetId())
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to get client for access")
+// hydrateClients replaces the clients decoded with an access record by their
+// current version, unless WithEmbeddedClient is in use and the record carries
+// them. A client that has since been deleted keeps its decoded version, so
+// its tokens can still be inspected and removed.
+func (s *Storage) hydrateClients(ctx context.Context, access *osin.AccessData) error {
+	if s.embeddedClient {
+		return nil
+	}
+
+	if access.Client != nil {
+		client, err := s.getCurrentClient(ctx, access.Client)
+		if err != nil {
+			return errors.Wrap(err, "unable to get client for access")
+		}
+		access.Client = client
 	}
 
 	if access.AuthorizeData != nil && access.AuthorizeData.Client != nil {
-		access.AuthorizeData.Client, err = s.GetClient(access.AuthorizeData.Client.GetId())
+		// The authorize data is normally issued to the same client.
+		if access.Client != nil && access.Client.GetId() == access.AuthorizeData.Client.GetId() {
+			access.AuthorizeData.Client = access.Client
+			return nil
+		}
+		client, err := s.getCurrentClient(ctx, access.AuthorizeData.Client)
 		if err != nil {
-			return nil, errors.Wrap(err, "unable to get client for access authorize data")
+			return errors.Wrap(err, "unable to get client for access authorize data")
 		}
+		access.AuthorizeData.Client = client
+	}
+	return nil
+}
+
+// stubClients replaces the clients decoded with an access record by stubs
+// carrying only their IDs.
+func stubClients(access *osin.AccessData) {
+	if access.Client != nil {
+		access.Client = &osin.DefaultClient{Id: access.Client.GetId()}
+	}
+	if access.AuthorizeData != nil && access.AuthorizeData.Client != nil {
+		access.AuthorizeData.Client = &osin.DefaultClient{Id: access.AuthorizeData.Client.GetId()}
+	}
+}
+
+func (s *Storage) getCurrentClient(ctx context.Context, decoded osin.Client) (osin.Client, error) {
+	client, err := s.getClient(ctx, decoded.GetId())
+	if errors.Is(err, ErrNotFound) {
+		return decoded, nil
+	}
+	return client, err
+}
+
+// getAccessID resolves an access_token or refresh_token pointer key to the
+// ID of the access record it refers to.
+func (s *Storage) getAccessID(ctx context.Context, key string) (string, error) {
+	return s.getAccessIDWith(ctx, s.pool, key)
+}
+
+func (s *Storage) getAccessIDWith(ctx context.Context, c redis.Cmdable, key string) (string, error) {
+	accessID, err := c.Get(ctx, key).Result()
+	if errors.Is(err, redis.Nil) {
+		return "", keyError(key, errors.Wrap(ErrNotFound, "unable to get access ID"))
+	}
+	return accessID, keyError(key, errors.Wrap(err, "unable to get access ID"))
+}
+
+// getAccess loads and decodes an access record without hydrating its clients.
+func (s *Storage) getAccess(ctx context.Context, accessID string) (*osin.AccessData, error) {
+	key := s.makeKey("access", accessID)
+	accessGob, err := s.pool.Get(ctx, key).Bytes()
+	if errors.Is(err, redis.Nil) {
+		return nil, keyError(key, errors.Wrap(ErrNotFound, "unable to get access gob"))
+	}
+	if err != nil {
+		return nil, keyError(key, errors.Wrap(err, "unable to get access gob"))
 	}
 
+	var access osin.AccessData
+	if err := s.decode(accessGob, &access); err != nil {
+		return nil, keyError(key, errors.Wrap(err, "failed to decode access gob"))
+	}
 	return &access, nil
 }
 
+// tokenKey returns the key in namespace for an access or refresh token, which
+// is named after the token's hash under WithTokenKeyHashing.
+func (s *Storage) tokenKey(namespace, token string) string {
+	return s.makeKey(namespace, s.tokenID(token))
+}
+
+// tokenID returns the id of the keys of token: the token itself, or its hash
+// under WithTokenKeyHashing.
+func (s *Storage) tokenID(token string) string {
+	if s.tokenHash != nil {
+		return s.tokenHash(token)
+	}
+	return token
+}
+
 func (s *Storage) makeKey(namespace, id string) string {
-	return fmt.Sprintf("%s:%s:%s", s.keyPrefix, namespace, id)
+	if s.escapeIDs {
+		id = escapeID(id, s.keySeparator)
+	}
+	namespace = s.namespaces.name(namespace)
+	prefix := s.keyPrefix
+	if s.clusterHashTags {
+		prefix = "{" + prefix + "}"
+	}
+	if s.keyFunc != nil {
+		return s.keyFunc(prefix, namespace, id)
+	}
+	return prefix + s.keySeparator + namespace + s.keySeparator + id
 }
 
-func encode(v interface{}) ([]byte, error) {
-	var buf bytes.Buffer
-	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
-		return nil, errors.Wrap(err, "unable to encode")
+// keyspaceClient returns the client to walk or watch the keyspace with: on a
+// ClusterClient, that of the master serving the slot WithClusterHashTags puts
+// every key in, since SCAN and keyspace notifications are local to a node.
+func (s *Storage) keyspaceClient(ctx context.Context) (redis.UniversalClient, error) {
+	cluster, ok := s.pool.(*redis.ClusterClient)
+	if !ok {
+		return s.pool, nil
 	}
-	return buf.Bytes(), nil
+	node, err := cluster.MasterForKey(ctx, s.makeKey("client", ""))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to find cluster node")
+	}
+	return node, nil
 }
 
-func decode(data []byte, v interface{}) error {
-	err := gob.NewDecoder(bytes.NewBuffer(data)).Decode(v)
-	return errors.Wrap(err, "unable to decode")
+func (s *Storage) decodeClient(data []byte) (osin.Client, error) {
+	var client osin.Client = &osin.DefaultClient{}
+	if s.newClient != nil {
+		client = s.newClient()
+	}
+	err := s.decode(data, client)
+	return client, errors.Wrap(err, "failed to decode client gob")
 }
@@ -2,8 +2,12 @@ package osinredis
 
 import (
 	"context"
+	"errors"
 	"os"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -43,7 +47,7 @@ func newAuthorizeData(client *osin.DefaultClient) *osin.AuthorizeData {
 		Client:      client,
 		Code:        "8888",
 		ExpiresIn:   3600,
-		CreatedAt:   time.Now(),
+		CreatedAt:   time.Now().Round(0),
 		RedirectUri: "http://localhost/",
 	}
 }
@@ -55,7 +59,7 @@ func newAccessData(authorizeData *osin.AuthorizeData) *osin.AccessData {
 		AccessToken:   "8888",
 		RefreshToken:  "r8888",
 		ExpiresIn:     3600,
-		CreatedAt:     time.Now(),
+		CreatedAt:     time.Now().Round(0),
 	}
 }
 
@@ -85,7 +89,7 @@ func TestGetClientNotFound(t *testing.T) {
 	storage := initTestStorage()
 
 	clientFound, err := storage.GetClient("notthere")
-	assert.NoError(t, err)
+	assert.ErrorIs(t, err, ErrNotFound)
 	assert.Nil(t, clientFound)
 }
 
@@ -130,13 +134,58 @@ func TestSaveAuthorize(t *testing.T) {
 	assert.NoError(t, storage.SaveAuthorize(authorizeData))
 }
 
+func TestSaveAuthorizeNonPositiveExpiry(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	for _, expiresIn := range []int32{0, -1} {
+		authorizeData := newAuthorizeData(client)
+		authorizeData.ExpiresIn = expiresIn
+		err := storage.SaveAuthorize(authorizeData)
+		assert.True(t, errors.Is(err, ErrInvalidExpiry), "ExpiresIn %d", expiresIn)
+		_, err = storage.LoadAuthorize(authorizeData.Code)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+}
+
+func TestWithMinAuthorizeTTL(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithMinAuthorizeTTL(90*time.Second))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	for _, expiresIn := range []int32{0, -1} {
+		authorizeData := newAuthorizeData(client)
+		authorizeData.ExpiresIn = expiresIn
+		assert.NoError(t, storage.SaveAuthorize(authorizeData))
+		assert.EqualValues(t, expiresIn, authorizeData.ExpiresIn)
+
+		loaded, err := storage.LoadAuthorize(authorizeData.Code)
+		assert.NoError(t, err)
+		assert.EqualValues(t, 90, loaded.ExpiresIn)
+		ttl, err := pool.TTL(context.Background(), "test123:auth:"+authorizeData.Code).Result()
+		assert.NoError(t, err)
+		assert.True(t, ttl > 0 && ttl <= 90*time.Second, "TTL %s", ttl)
+	}
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	loaded, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	assert.EqualValues(t, authorizeData.ExpiresIn, loaded.ExpiresIn)
+}
+
 func TestLoadAuthorizeNonExistent(t *testing.T) {
 	flushAll()
 
 	storage := initTestStorage()
 	loadData, err := storage.LoadAuthorize("nonExistentCode")
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.ErrorIs(t, err, ErrNotFound)
 }
 
 func TestLoadAuthorize(t *testing.T) {
@@ -176,7 +225,7 @@ func TestRemoveAuthorize(t *testing.T) {
 
 	loadData, err := storage.LoadAuthorize(authorizeData.Code)
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.ErrorIs(t, err, ErrNotFound)
 }
 
 func TestSaveAccess(t *testing.T) {
@@ -194,6 +243,26 @@ func TestSaveAccess(t *testing.T) {
 	assert.NoError(t, storage.SaveAccess(accessData))
 }
 
+func TestSaveAccessTTL(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	accessData.ExpiresIn = 3600
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	ttl, err := pool.TTL(context.Background(), storage.makeKey("access_token", accessData.AccessToken)).Result()
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 1)
+}
+
 func TestLoadAccessNonExistent(t *testing.T) {
 	flushAll()
 
@@ -201,7 +270,7 @@ func TestLoadAccessNonExistent(t *testing.T) {
 
 	loadData, err := storage.LoadAccess("nonExistentToken")
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.ErrorIs(t, err, ErrNotFound)
 }
 
 func TestLoadAccess(t *testing.T) {
@@ -228,13 +297,35 @@ func TestLoadAccess(t *testing.T) {
 	assert.NoError(t, err)
 }
 
+func TestLoadAccessExpiresInSeconds(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	accessData.ExpiresIn = 3600
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	if assert.NotNil(t, loadData) {
+		assert.True(t, loadData.ExpiresIn > 3590 && loadData.ExpiresIn <= 3600, "ExpiresIn = %d", loadData.ExpiresIn)
+	}
+}
+
 func TestRemoveAccessNonExistent(t *testing.T) {
 	flushAll()
 
 	storage := initTestStorage()
 
 	err := storage.RemoveAccess("nonExistentToken")
-	assert.Error(t, err)
+	assert.NoError(t, err)
 }
 
 func TestRemoveAccess(t *testing.T) {
@@ -254,7 +345,7 @@ func TestRemoveAccess(t *testing.T) {
 
 	loadData, err := storage.LoadAccess(accessData.AccessToken)
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.ErrorIs(t, err, ErrNotFound)
 }
 
 func TestLoadRefreshNonExistent(t *testing.T) {
@@ -264,7 +355,7 @@ func TestLoadRefreshNonExistent(t *testing.T) {
 
 	loadData, err := storage.LoadRefresh("nonExistentToken")
 	assert.Nil(t, loadData)
-	assert.NoError(t, err)
+	assert.ErrorIs(t, err, ErrNotFound)
 }
 
 func TestLoadRefresh(t *testing.T) {
@@ -292,7 +383,7 @@ func TestRemoveRefreshNonExistent(t *testing.T) {
 	storage := initTestStorage()
 
 	err := storage.RemoveRefresh("nonExistentToken")
-	assert.Error(t, err)
+	assert.NoError(t, err)
 }
 
 func TestRemoveRefresh(t *testing.T) {
@@ -314,5 +405,1087 @@ func TestRemoveRefresh(t *testing.T) {
 
 	loadData, err := storage.LoadRefresh(accessData.RefreshToken)
 	assert.Nil(t, loadData)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRefreshRoundTrip(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	accessData.RefreshToken = "distinct-refresh"
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	ctx := context.Background()
+	refreshKey := storage.makeKey("refresh_token", accessData.RefreshToken)
+	assert.EqualValues(t, 1, pool.Exists(ctx, refreshKey).Val())
+
+	loadData, err := storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	if assert.NotNil(t, loadData) {
+		assert.Equal(t, accessData.AccessToken, loadData.AccessToken)
+		assert.Equal(t, accessData.RefreshToken, loadData.RefreshToken)
+	}
+
+	assert.NoError(t, storage.RemoveRefresh(accessData.RefreshToken))
+	assert.EqualValues(t, 0, pool.Exists(ctx, refreshKey).Val())
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("access_token", accessData.AccessToken)).Val())
+}
+
+func TestSaveAccessWithoutRefreshToken(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	accessData := newAccessData(authorizeData)
+	accessData.RefreshToken = ""
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	ctx := context.Background()
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("refresh_token", "")).Val())
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("access_token", accessData.AccessToken)).Val())
+}
+
+func TestWithContext(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	scoped := storage.WithContext(ctx)
+	assert.Equal(t, ctx, scoped.Context())
+	assert.Equal(t, context.Background(), storage.Context())
+	assert.Same(t, storage.pool, scoped.pool)
+	assert.Equal(t, storage.keyPrefix, scoped.keyPrefix)
+
+	_, err := scoped.GetClient(client.GetId())
+	assert.ErrorIs(t, err, context.Canceled)
+
+	_, err = storage.GetClientContext(ctx, client.GetId())
+	assert.ErrorIs(t, err, context.Canceled)
+
+	clientFound, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, client, clientFound)
+}
+
+func TestLoadAccessDanglingPointer(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	ctx := context.Background()
+	assert.NoError(t, pool.Set(ctx, storage.makeKey("access_token", "dangling"), "missingAccessID", 0).Err())
+	assert.NoError(t, pool.Set(ctx, storage.makeKey("refresh_token", "dangling"), "missingAccessID", 0).Err())
+
+	loadData, err := storage.LoadAccess("dangling")
+	assert.Nil(t, loadData)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	loadData, err = storage.LoadRefresh("dangling")
+	assert.Nil(t, loadData)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestTransportErrorIsNotErrNotFound(t *testing.T) {
+	unreachable := redis.NewClient(&redis.Options{Addr: "127.0.0.1:1", MaxRetries: -1})
+	defer unreachable.Close()
+
+	storage := New(unreachable, "test123")
+
+	_, err := storage.GetClient("clientID")
+	assert.Error(t, err)
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
+
+// failingWriteHook replaces the nth command queued in a MULTI/EXEC block with
+// a malformed one, which makes Redis discard the whole transaction.
+type failingWriteHook struct {
+	n int
+}
+
+func (h failingWriteHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h failingWriteHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return next
+}
+
+func (h failingWriteHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		// cmds[0] is MULTI, so the nth write sits at index n.
+		if len(cmds) > h.n && cmds[0].Name() == "multi" {
+			cmds[h.n] = redis.NewStatusCmd(ctx, "setex", cmds[h.n].Args()[1])
+		}
+		return next(ctx, cmds)
+	}
+}
+
+func TestSaveAccessAtomic(t *testing.T) {
+	flushAll()
+
+	failing := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	defer failing.Close()
+	failing.AddHook(failingWriteHook{n: 2})
+
+	storage := New(failing, "test123")
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	accessData := newAccessData(authorizeData)
+	assert.Error(t, storage.SaveAccess(accessData))
+
+	keys, err := pool.Keys(context.Background(), "test123:access*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.EqualValues(t, 0, pool.Exists(context.Background(), storage.makeKey("refresh_token", accessData.RefreshToken)).Val())
+}
+
+func TestRemoveAccessConcurrent(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		errs[0] = storage.RemoveAccess(accessData.AccessToken)
+	}()
+	go func() {
+		defer wg.Done()
+		errs[1] = storage.RemoveRefresh(accessData.RefreshToken)
+	}()
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			assert.ErrorIs(t, err, ErrNotFound)
+		}
+	}
+
+	keys, err := pool.Keys(context.Background(), "test123:*token*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	keys, err = pool.Keys(context.Background(), "test123:access:*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestRemoveAccessDanglingPointer(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	ctx := context.Background()
+	key := storage.makeKey("access_token", "dangling")
+	assert.NoError(t, pool.Set(ctx, key, "missingAccessID", 0).Err())
+
+	assert.NoError(t, storage.RemoveAccess("dangling"))
+	assert.EqualValues(t, 0, pool.Exists(ctx, key).Val())
+}
+
+func TestClientTTL(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	client := newClient()
+
+	storage := initTestStorage()
+	assert.NoError(t, storage.CreateClient(client))
+	assert.Equal(t, time.Duration(-1), pool.TTL(ctx, storage.makeKey("client", client.GetId())).Val())
+
+	storage = New(pool, "test123", WithClientTTL(time.Hour))
+	assert.NoError(t, storage.CreateClient(client))
+	assert.InDelta(t, time.Hour.Seconds(), pool.TTL(ctx, storage.makeKey("client", client.GetId())).Val().Seconds(), 1)
+
+	assert.NoError(t, pool.Expire(ctx, storage.makeKey("client", client.GetId()), time.Minute).Err())
+	assert.NoError(t, storage.UpdateClient(client))
+	assert.InDelta(t, time.Hour.Seconds(), pool.TTL(ctx, storage.makeKey("client", client.GetId())).Val().Seconds(), 1)
+}
+
+func TestRefreshTTL(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithRefreshTTL(30*24*time.Hour))
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	accessTTL := pool.TTL(ctx, storage.makeKey("access_token", accessData.AccessToken)).Val()
+	assert.InDelta(t, time.Hour.Seconds(), accessTTL.Seconds(), 1)
+	refreshTTL := pool.TTL(ctx, storage.makeKey("refresh_token", accessData.RefreshToken)).Val()
+	assert.InDelta(t, (30 * 24 * time.Hour).Seconds(), refreshTTL.Seconds(), 1)
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.InDelta(t, 3600, loadData.ExpiresIn, 1)
+
+	// LoadRefresh reports the life of the refresh token.
+	loadData, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.InDelta(t, (30 * 24 * time.Hour).Seconds(), loadData.ExpiresIn, 1)
+
+	// Once the access token is gone the refresh token still loads.
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("access_token", accessData.AccessToken)).Err())
+	loadData, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.InDelta(t, (30 * 24 * time.Hour).Seconds(), loadData.ExpiresIn, 1)
+}
+
+func TestPing(t *testing.T) {
+	storage := initTestStorage()
+	assert.NoError(t, storage.Ping(context.Background()))
+
+	// A non-routable address never answers, so only the deadline ends the probe.
+	unreachable := redis.NewClient(&redis.Options{Addr: "10.255.255.1:6379", DialTimeout: time.Minute})
+	defer unreachable.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	assert.Error(t, New(unreachable, "test123").Ping(ctx))
+	assert.Less(t, time.Since(start), 5*time.Second)
+}
+
+type sequentialIDs struct {
+	n   int
+	err error
+}
+
+func (g *sequentialIDs) NewID() (string, error) {
+	g.n++
+	return "id" + strconv.Itoa(g.n), g.err
+}
+
+func TestWithIDGenerator(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	generator := &sequentialIDs{}
+	storage := New(pool, "test123", WithIDGenerator(generator))
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	accessID, err := pool.Get(ctx, "test123:access_token:"+accessData.AccessToken).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, "id1", accessID)
+	exists, err := pool.Exists(ctx, "test123:access:id1").Result()
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, exists)
+
+	generator.err = errors.New("entropy exhausted")
+	assert.Error(t, storage.SaveAccess(accessData))
+}
+
+func TestLoadAccessWithTTL(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithRefreshTTL(24*time.Hour))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, pool.Expire(ctx, "test123:access_token:"+accessData.AccessToken, time.Minute).Err())
+
+	loadData, ttl, err := storage.LoadAccessWithTTL(ctx, accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl)
+	assert.EqualValues(t, 3600, loadData.ExpiresIn)
+
+	loadData, err = storage.LoadAccess(accessData.AccessToken)
 	assert.NoError(t, err)
+	assert.EqualValues(t, 60, loadData.ExpiresIn)
+
+	_, _, err = storage.LoadAccessWithTTL(ctx, "unknown")
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestWithEmbeddedClient(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithEmbeddedClient())
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.DeleteClient(client))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, client, loadData.Client)
+	assert.Equal(t, client, loadData.AuthorizeData.Client)
+
+	loadData, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, client, loadData.Client)
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+}
+
+func TestLoadAccessDeletedClient(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.NoError(t, storage.DeleteClient(client))
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, client.GetId(), loadData.Client.GetId())
+	assert.Equal(t, client.GetId(), loadData.AuthorizeData.Client.GetId())
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+// deadlineHook records whether each command ran with a deadline.
+type deadlineHook struct {
+	mu        sync.Mutex
+	deadlines []bool
+}
+
+func (h *deadlineHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *deadlineHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		_, ok := ctx.Deadline()
+		h.mu.Lock()
+		h.deadlines = append(h.deadlines, ok)
+		h.mu.Unlock()
+		return next(ctx, cmd)
+	}
+}
+
+func (h *deadlineHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestWithDefaultTimeout(t *testing.T) {
+	flushAll()
+
+	hook := &deadlineHook{}
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	client.AddHook(hook)
+	defer client.Close()
+
+	storage := New(client, "test123", WithDefaultTimeout(time.Second))
+
+	_, err := storage.GetClient("notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = storage.GetClientContext(context.Background(), "notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = storage.WithContext(context.Background()).GetClient("notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	assert.Equal(t, []bool{true, false, false}, hook.deadlines)
+}
+
+// roundTripHook counts the requests sent to Redis, pipelines counting once.
+type roundTripHook struct {
+	mu sync.Mutex
+	n  int
+}
+
+func (h *roundTripHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *roundTripHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		h.mu.Lock()
+		h.n++
+		h.mu.Unlock()
+		return next(ctx, cmd)
+	}
+}
+
+func (h *roundTripHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		h.mu.Lock()
+		h.n++
+		h.mu.Unlock()
+		return next(ctx, cmds)
+	}
+}
+
+func BenchmarkLoadAccess(b *testing.B) {
+	flushAll()
+
+	hook := &roundTripHook{}
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	client.AddHook(hook)
+	defer client.Close()
+
+	storage := New(client, "test123")
+	osinClient := newClient()
+	if err := storage.CreateClient(osinClient); err != nil {
+		b.Fatal(err)
+	}
+	accessData := newAccessData(newAuthorizeData(osinClient))
+	if err := storage.SaveAccess(accessData); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, bench := range []struct {
+		name string
+		load func(string) (*osin.AccessData, error)
+		arg  string
+	}{
+		{"Access", storage.LoadAccess, accessData.AccessToken},
+		{"Refresh", storage.LoadRefresh, accessData.RefreshToken},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			hook.n = 0
+			for i := 0; i < b.N; i++ {
+				if _, err := bench.load(bench.arg); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(hook.n)/float64(b.N), "roundtrips/op")
+		})
+	}
+}
+
+func TestLoadAuthorizeRevoked(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	assert.NoError(t, storage.RemoveAuthorize(authorizeData.Code))
+
+	_, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.ErrorIs(t, err, ErrRevoked)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	ttl, err := pool.TTL(ctx, storage.makeKey("auth_revoked", authorizeData.Code)).Result()
+	assert.NoError(t, err)
+	assert.InDelta(t, time.Hour.Seconds(), ttl.Seconds(), 1)
+
+	_, err = storage.LoadAuthorize("unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, errors.Is(err, ErrRevoked))
+
+	// Removing an unknown or expired code leaves no tombstone.
+	assert.NoError(t, storage.RemoveAuthorize("unknown"))
+	_, err = storage.LoadAuthorize("unknown")
+	assert.False(t, errors.Is(err, ErrRevoked))
+}
+
+func TestConsumeAuthorize(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		consumed int
+	)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			data, err := storage.ConsumeAuthorize(ctx, authorizeData.Code)
+			mu.Lock()
+			defer mu.Unlock()
+			if err == nil {
+				consumed++
+				assert.Equal(t, authorizeData, data)
+			} else {
+				assert.ErrorIs(t, err, ErrRevoked)
+			}
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 1, consumed)
+
+	_, err := storage.LoadAuthorize(authorizeData.Code)
+	assert.ErrorIs(t, err, ErrRevoked)
+
+	_, err = storage.ConsumeAuthorize(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.False(t, errors.Is(err, ErrRevoked))
+}
+
+func TestNewFromOptions(t *testing.T) {
+	flushAll()
+
+	storage, err := NewFromOptions(&redis.Options{Addr: os.Getenv("REDIS_ADDR")}, "test123")
+	assert.NoError(t, err)
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	// Clones share the client without owning it.
+	storage.Clone().Close()
+	storage.WithContext(context.Background()).Close()
+	_, err = storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+
+	storage.Close()
+	_, err = storage.GetClient(client.GetId())
+	assert.ErrorIs(t, err, redis.ErrClosed)
+
+	_, err = NewFromOptions(nil, "test123")
+	assert.Error(t, err)
+}
+
+func TestCloseOwnedClient(t *testing.T) {
+	flushAll()
+
+	client := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	New(client, "test123").Close()
+	assert.NoError(t, client.Ping(context.Background()).Err())
+
+	New(client, "test123", WithOwnedClient()).Close()
+	assert.ErrorIs(t, client.Ping(context.Background()).Err(), redis.ErrClosed)
+}
+
+func TestSaveAccessID(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+
+	accessID, err := storage.SaveAccessID(ctx, accessData)
+	assert.NoError(t, err)
+
+	pointer, err := pool.Get(ctx, storage.makeKey("access_token", accessData.AccessToken)).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, pointer, accessID)
+
+	familyID, err := storage.RefreshFamily(ctx, accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessID, familyID)
+}
+
+// TestConcurrentAccess is meant to be run with -race.
+func TestConcurrentAccess(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithClientCache(16, time.Minute))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				accessData := newAccessData(newAuthorizeData(client))
+				accessData.AccessToken = "access-" + strconv.Itoa(i) + "-" + strconv.Itoa(j)
+				accessData.RefreshToken = "refresh-" + strconv.Itoa(i) + "-" + strconv.Itoa(j)
+				if !assert.NoError(t, storage.SaveAccess(accessData)) {
+					return
+				}
+				loaded, err := storage.WithContext(context.Background()).LoadAccess(accessData.AccessToken)
+				if !assert.NoError(t, err) {
+					return
+				}
+				assert.Equal(t, accessData.AccessToken, loaded.AccessToken)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+// noTTLHook fails every TTL command without sending it, like a proxy that
+// restricts it.
+type noTTLHook struct{}
+
+var errTTLRestricted = errors.New("ERR unknown command 'TTL'")
+
+func (noTTLHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (noTTLHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if cmd.Name() == "ttl" {
+			cmd.SetErr(errTTLRestricted)
+			return errTTLRestricted
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (noTTLHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return func(ctx context.Context, cmds []redis.Cmder) error {
+		var sent []redis.Cmder
+		for _, cmd := range cmds {
+			if cmd.Name() == "ttl" {
+				cmd.SetErr(errTTLRestricted)
+			} else {
+				sent = append(sent, cmd)
+			}
+		}
+		return next(ctx, sent)
+	}
+}
+
+func TestWithLenientTTL(t *testing.T) {
+	flushAll()
+
+	restricted := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	defer restricted.Close()
+	restricted.AddHook(noTTLHook{})
+
+	client := newClient()
+	accessData := newAccessData(newAuthorizeData(client))
+	storage := New(restricted, "test123")
+	assert.NoError(t, storage.CreateClient(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.ErrorIs(t, err, errTTLRestricted)
+
+	logger := &recordingLogger{}
+	storage = New(restricted, "test123", WithLenientTTL(), WithLogger(logger))
+	loaded, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.ExpiresIn, loaded.ExpiresIn)
+	assert.Len(t, logger.lines, 1)
+
+	loaded, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loaded.AccessToken)
+}
+
+// keyHook records the keys of the single commands sent to Redis.
+type keyHook struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (h *keyHook) DialHook(next redis.DialHook) redis.DialHook {
+	return next
+}
+
+func (h *keyHook) ProcessHook(next redis.ProcessHook) redis.ProcessHook {
+	return func(ctx context.Context, cmd redis.Cmder) error {
+		if args := cmd.Args(); len(args) > 1 {
+			h.mu.Lock()
+			h.keys = append(h.keys, cmd.Name()+" "+args[1].(string))
+			h.mu.Unlock()
+		}
+		return next(ctx, cmd)
+	}
+}
+
+func (h *keyHook) ProcessPipelineHook(next redis.ProcessPipelineHook) redis.ProcessPipelineHook {
+	return next
+}
+
+func TestLoadAccessSharedClient(t *testing.T) {
+	flushAll()
+
+	hook := &keyHook{}
+	recorded := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	recorded.AddHook(hook)
+	defer recorded.Close()
+
+	storage := New(recorded, "test123")
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	hook.keys = nil
+	loaded, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"get " + storage.makeKey("client", client.GetId())}, filterPrefix(hook.keys, "get "+storage.makeKey("client", "")))
+	assert.Same(t, loaded.Client, loaded.AuthorizeData.Client)
+}
+
+func TestLoadAccessLite(t *testing.T) {
+	flushAll()
+
+	hook := &keyHook{}
+	recorded := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	recorded.AddHook(hook)
+	defer recorded.Close()
+
+	storage := New(recorded, "test123")
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	hook.keys = nil
+	loaded, err := storage.LoadAccessLite(context.Background(), accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Empty(t, filterPrefix(hook.keys, "get "+storage.makeKey("client", "")))
+	assert.Equal(t, accessData.RefreshToken, loaded.RefreshToken)
+	assert.Equal(t, &osin.DefaultClient{Id: client.Id}, loaded.Client)
+	assert.Equal(t, &osin.DefaultClient{Id: client.Id}, loaded.AuthorizeData.Client)
+	assert.InDelta(t, accessData.ExpiresIn, loaded.ExpiresIn, 1)
+
+	_, err = storage.LoadAccessLite(context.Background(), "notthere")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func filterPrefix(values []string, prefix string) []string {
+	var filtered []string
+	for _, v := range values {
+		if strings.HasPrefix(v, prefix) {
+			filtered = append(filtered, v)
+		}
+	}
+	return filtered
+}
+
+func TestWithReadClient(t *testing.T) {
+	flushAll()
+
+	primaryHook, replicaHook := &roundTripHook{}, &roundTripHook{}
+	primary := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	primary.AddHook(primaryHook)
+	defer primary.Close()
+	replica := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	replica.AddHook(replicaHook)
+	defer replica.Close()
+
+	storage := New(primary, "test123", WithReadClient(replica))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	authorizeData := newAuthorizeData(client)
+	assert.NoError(t, storage.SaveAuthorize(authorizeData))
+	accessData := newAccessData(authorizeData)
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.Zero(t, replicaHook.n)
+
+	primaryHook.n = 0
+	_, err := storage.GetClient(client.GetId())
+	assert.NoError(t, err)
+	_, err = storage.LoadAuthorize(authorizeData.Code)
+	assert.NoError(t, err)
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Zero(t, primaryHook.n)
+	assert.NotZero(t, replicaHook.n)
+
+	replicaHook.n = 0
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	assert.Zero(t, replicaHook.n)
+}
+
+func TestRemoveAccessTwice(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	assert.NoError(t, storage.RemoveRefresh(accessData.RefreshToken))
+	assert.NoError(t, storage.RemoveRefresh("unknown"))
+}
+
+func TestOpError(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+
+	_, err := storage.LoadAccess("unknown")
+	var opErr *OpError
+	if assert.True(t, errors.As(err, &opErr)) {
+		assert.Equal(t, "LoadAccess", opErr.Op)
+		assert.Equal(t, storage.makeKey("access_token", "unknown"), opErr.Key)
+	}
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.NotContains(t, err.Error(), "unknown")
+
+	_, err = storage.GetClient("unknown")
+	if assert.True(t, errors.As(err, &opErr)) {
+		assert.Equal(t, "GetClient", opErr.Op)
+		assert.Equal(t, storage.makeKey("client", "unknown"), opErr.Key)
+	}
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = storage.LoadAuthorize("unknown")
+	if assert.True(t, errors.As(err, &opErr)) {
+		assert.Equal(t, "LoadAuthorize", opErr.Op)
+		assert.Equal(t, storage.makeKey("auth", "unknown"), opErr.Key)
+	}
+
+	// Operations spanning several keys report none.
+	_, err = New(pool, "test123", WithMaxPayloadSize(1)).SaveAccessID(context.Background(), newAccessData(newAuthorizeData(newClient())))
+	if assert.True(t, errors.As(err, &opErr)) {
+		assert.Equal(t, "SaveAccess", opErr.Op)
+		assert.Empty(t, opErr.Key)
+	}
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+func TestWithSetNX(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithSetNX())
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	collision := newAccessData(newAuthorizeData(client))
+	collision.RefreshToken = "other"
+	assert.ErrorIs(t, storage.SaveAccess(collision), ErrTokenCollision)
+	_, err := storage.LoadRefresh("other")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	rotated := newAccessData(newAuthorizeData(client))
+	rotated.AccessToken = "rotated"
+	assert.ErrorIs(t, storage.RotateRefresh(ctx, accessData.RefreshToken, rotated), ErrTokenCollision)
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+
+	rotated.RefreshToken = "rotated"
+	assert.NoError(t, storage.RotateRefresh(ctx, accessData.RefreshToken, rotated))
+
+	// The default overwrites.
+	assert.NoError(t, initTestStorage().SaveAccess(collision))
+	loaded, err := storage.LoadAccess(collision.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, "other", loaded.RefreshToken)
+}
+
+func TestWithTokenKeyHashing(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithTokenKeyHashing(nil))
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	keys, err := pool.Keys(ctx, "test123:*").Result()
+	assert.NoError(t, err)
+	for _, key := range keys {
+		assert.False(t, strings.HasSuffix(key, ":"+accessData.AccessToken), key)
+		assert.False(t, strings.HasSuffix(key, ":"+accessData.RefreshToken), key)
+	}
+	// The SHA-256 of "8888".
+	hashed := storage.makeKey("access_token", "2926a2731f4b312c08982cacf8061eb14bf65c1a87cc5d70e864e079c6220731")
+	assert.EqualValues(t, 1, pool.Exists(ctx, hashed).Val())
+
+	loaded, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loaded.AccessToken)
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+
+	rotated := newAccessData(newAuthorizeData(client))
+	rotated.AccessToken, rotated.RefreshToken = "rotated", "rrotated"
+	assert.NoError(t, storage.RotateRefresh(ctx, accessData.RefreshToken, rotated))
+	reused, err := storage.DetectRefreshReuse(ctx, accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.True(t, reused)
+
+	assert.NoError(t, storage.RemoveAccess(rotated.AccessToken))
+	_, err = storage.LoadRefresh(rotated.RefreshToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	custom := New(pool, "test123", WithTokenKeyHashing(strings.ToUpper))
+	assert.NoError(t, custom.SaveAccess(accessData))
+	assert.EqualValues(t, 1, pool.Exists(ctx, custom.makeKey("refresh_token", "R8888")).Val())
+}
+
+func TestEmptyIdentifiers(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(saved))
+
+	noCode := newAuthorizeData(client)
+	noCode.Code = ""
+	noToken := newAccessData(newAuthorizeData(client))
+	noToken.AccessToken = ""
+
+	tests := []struct {
+		name string
+		err  error
+		want error
+	}{
+		{"CreateClient", storage.CreateClient(&osin.DefaultClient{}), ErrInvalidClientID},
+		{"UpdateClient", storage.UpdateClient(&osin.DefaultClient{}), ErrInvalidClientID},
+		{"CreateClients", storage.CreateClients(ctx, []osin.Client{&osin.DefaultClient{}}), ErrInvalidClientID},
+		{"SaveAuthorize", storage.SaveAuthorize(noCode), ErrInvalidToken},
+		{"SaveAccess", storage.SaveAccess(noToken), ErrInvalidToken},
+		{"RotateRefresh", storage.RotateRefresh(ctx, saved.RefreshToken, noToken), ErrInvalidToken},
+	}
+	for _, test := range tests {
+		assert.ErrorIs(t, test.err, test.want, test.name)
+	}
+
+	keys, err := pool.Keys(ctx, "test123:*:").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+}
+
+func TestWithStatelessAccess(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithStatelessAccess())
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("access_token", accessData.AccessToken)).Val())
+
+	_, err := storage.LoadAccess(accessData.AccessToken)
+	assert.ErrorIs(t, err, ErrStateless)
+	assert.NotErrorIs(t, err, ErrNotFound)
+	_, err = storage.Introspect(ctx, accessData.AccessToken)
+	assert.ErrorIs(t, err, ErrStateless)
+	_, err = storage.TokenTTL(ctx, accessData.AccessToken)
+	assert.ErrorIs(t, err, ErrStateless)
+
+	loaded, err := storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, accessData.AccessToken, loaded.AccessToken)
+
+	assert.NoError(t, storage.RemoveRefresh(accessData.RefreshToken))
+	keys, err := pool.Keys(ctx, "test123:access*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// Revoking by index is guarded by the refresh token pointer instead.
+	assert.NoError(t, storage.SaveAccess(accessData))
+	revoked, err := storage.RevokeAllForClient(ctx, client.Id)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestStatelessAccessSetNX(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := New(pool, "test123", WithStatelessAccess(), WithSetNX())
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+
+	accessData := newAccessData(newAuthorizeData(client))
+	accessData.RefreshToken = ""
+	assert.NoError(t, storage.SaveAccess(accessData))
+	// Nothing refers to the access record, so it is not written.
+	keys, err := pool.Keys(ctx, "test123:*access*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("client_tokens", client.Id)).Val())
+
+	withRefresh := newAccessData(newAuthorizeData(client))
+	withRefresh.AccessToken = "9999"
+	assert.NoError(t, storage.SaveAccess(withRefresh))
+	rotated := newAccessData(newAuthorizeData(client))
+	rotated.AccessToken = "7777"
+	rotated.RefreshToken = ""
+	assert.NoError(t, storage.RotateRefresh(ctx, withRefresh.RefreshToken, rotated))
+}
+
+func TestNilClient(t *testing.T) {
+	flushAll()
+
+	ctx := context.Background()
+	storage := initTestStorage()
+	authorizeData := newAuthorizeData(newClient())
+	authorizeData.Client = nil
+	accessData := newAccessData(authorizeData)
+	accessData.Client = nil
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	loaded, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Nil(t, loaded.Client)
+	assert.Nil(t, loaded.AuthorizeData.Client)
+
+	batch, err := storage.LoadAccessBatch(ctx, []string{accessData.AccessToken})
+	assert.NoError(t, err)
+	assert.Nil(t, batch[accessData.AccessToken].Client)
+
+	lite, err := storage.LoadAccessLite(ctx, accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.Nil(t, lite.Client)
+
+	result, err := storage.Introspect(ctx, accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.True(t, result.Active)
+	assert.Empty(t, result.ClientID)
+
+	assert.NoError(t, storage.RemoveRefresh(accessData.RefreshToken))
+	_, err = storage.LoadAccess(accessData.AccessToken)
+	assert.ErrorIs(t, err, ErrNotFound)
 }
@@ -0,0 +1,41 @@
+package osinredis
+
+import (
+	"context"
+	"strings"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+)
+
+// SaveAccessWithTags is SaveAccessID storing tags, such as "mfa" => "true",
+// with the access record, in a hash that lives and is removed with it. Under
+// WithTagIndex the access is also indexed under each tag for
+// FindTokensByTag. Tag keys must not contain "=".
+func (s *Storage) SaveAccessWithTags(ctx context.Context, data *osin.AccessData, tags map[string]string) (_ string, err error) {
+	ctx, done := s.begin(ctx, "SaveAccessWithTags")
+	defer done(&err)
+	for key := range tags {
+		if key == "" || strings.Contains(key, "=") {
+			return "", errors.Errorf("osinredis: invalid tag key %q", key)
+		}
+	}
+	return s.saveAccess(ctx, data, tags)
+}
+
+// FindTokensByTag returns the live access tokens saved with the tag key set
+// to value, which requires WithTagIndex. Entries whose access record is gone
+// are dropped from the index along the way.
+func (s *Storage) FindTokensByTag(ctx context.Context, key, value string) (_ []string, err error) {
+	ctx, done := s.begin(ctx, "FindTokensByTag")
+	defer done(&err)
+	if s.statelessAccess {
+		return nil, ErrStateless
+	}
+	return s.indexedTokens(ctx, s.makeKey("tag", tagID(key, value)))
+}
+
+// tagID returns the id of the index set of the tag key set to value.
+func tagID(key, value string) string {
+	return key + "=" + value
+}
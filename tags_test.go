@@ -0,0 +1,76 @@
+package osinredis
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSaveAccessWithTags(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithTagIndex())
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 2)
+	tags := []map[string]string{
+		{"mfa": "true", "ip": "1.2.3.4"},
+		{"mfa": "false", "ip": "1.2.3.4"},
+	}
+	accessIDs := make([]string, len(saved))
+	for i, accessData := range saved {
+		assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+		accessID, err := storage.SaveAccessWithTags(ctx, accessData, tags[i])
+		assert.NoError(t, err)
+		accessIDs[i] = accessID
+	}
+
+	stored, err := pool.HGetAll(ctx, storage.makeKey("access_tags", accessIDs[0])).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, tags[0], stored)
+	ttl := pool.TTL(ctx, storage.makeKey("access_tags", accessIDs[0])).Val()
+	assert.InDelta(t, 3600, ttl.Seconds(), 1)
+
+	tokens, err := storage.FindTokensByTag(ctx, "ip", "1.2.3.4")
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{saved[0].AccessToken, saved[1].AccessToken}, tokens)
+	tokens, err = storage.FindTokensByTag(ctx, "mfa", "true")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{saved[0].AccessToken}, tokens)
+
+	// Removing a token deletes its tags, and its index entries go lazily.
+	assert.NoError(t, storage.RemoveAccess(saved[0].AccessToken))
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("access_tags", accessIDs[0])).Val())
+	tokens, err = storage.FindTokensByTag(ctx, "ip", "1.2.3.4")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{saved[1].AccessToken}, tokens)
+	assert.EqualValues(t, 1, pool.SCard(ctx, storage.makeKey("tag", "ip=1.2.3.4")).Val())
+
+	tokens, err = storage.FindTokensByTag(ctx, "mfa", "unknown")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+
+	_, err = storage.SaveAccessWithTags(ctx, newAccessData(newAuthorizeData(client)), map[string]string{"a=b": "c"})
+	assert.Error(t, err)
+}
+
+func TestSaveAccessWithTagsUnindexed(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessID, err := storage.SaveAccessWithTags(ctx, newAccessData(newAuthorizeData(client)), map[string]string{"mfa": "true"})
+	assert.NoError(t, err)
+
+	assert.Equal(t, "true", pool.HGet(ctx, storage.makeKey("access_tags", accessID), "mfa").Val())
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("tag", "mfa=true")).Val())
+	tokens, err := storage.FindTokensByTag(ctx, "mfa", "true")
+	assert.NoError(t, err)
+	assert.Empty(t, tokens)
+}
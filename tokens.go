@@ -0,0 +1,596 @@
+package osinredis
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/pkg/errors"
+	"github.com/redis/go-redis/v9"
+)
+
+// RevokeAllForClient removes every access token issued to clientID, along with
+// their refresh tokens, and returns how many were revoked.
+//
+// SaveAccess indexes each access under its client; entries whose record has
+// already expired are skipped and dropped from the index.
+// RevokeAllForClientDryRun lists the keys it would delete.
+func (s *Storage) RevokeAllForClient(ctx context.Context, clientID string) (_ int, err error) {
+	ctx, done := s.begin(ctx, "RevokeAllForClient")
+	defer done(&err)
+	return s.revokeIndexed(ctx, s.makeKey("client_tokens", clientID), "client tokens")
+}
+
+// RevokeAllForUser removes every access token whose UserData the function
+// set with WithUserExtractor maps to userID, along with their refresh tokens,
+// and returns how many were revoked, as RevokeAllForClient does for a client.
+func (s *Storage) RevokeAllForUser(ctx context.Context, userID string) (_ int, err error) {
+	ctx, done := s.begin(ctx, "RevokeAllForUser")
+	defer done(&err)
+	return s.revokeIndexed(ctx, s.makeKey("user_tokens", userID), "user tokens")
+}
+
+// revokeBatchSize is the number of access records a worker of revokeIndexed
+// loads and deletes per round trip.
+const revokeBatchSize = 100
+
+// revokeIndexed removes the access records whose IDs are in the index set
+// setKey, and each ID from the set once its record is revoked or found gone,
+// so the set only disappears when every member was. The records are revoked
+// in batches by up to revokeConcurrency workers; the first error stops them
+// and is returned along with the number revoked until then.
+func (s *Storage) revokeIndexed(ctx context.Context, setKey, index string) (int, error) {
+	accessIDs, err := s.pool.SMembers(ctx, setKey).Result()
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to get %s", index)
+	}
+	if len(accessIDs) > 0 {
+		if err := removeAccessScript.Load(ctx, s.pool).Err(); err != nil {
+			return 0, errors.Wrap(err, "failed to delete access")
+		}
+	}
+
+	workCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var (
+		wg       sync.WaitGroup
+		mu       sync.Mutex
+		revoked  int
+		firstErr error
+	)
+	batches := make(chan []string)
+	for i := 0; i < s.revokeConcurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for batch := range batches {
+				n, err := s.revokeBatch(workCtx, setKey, batch)
+				mu.Lock()
+				revoked += n
+				if err != nil && firstErr == nil {
+					firstErr = err
+					cancel()
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+
+feed:
+	for start := 0; start < len(accessIDs); start += revokeBatchSize {
+		end := start + revokeBatchSize
+		if end > len(accessIDs) {
+			end = len(accessIDs)
+		}
+		select {
+		case batches <- accessIDs[start:end]:
+		case <-workCtx.Done():
+			break feed
+		}
+	}
+	close(batches)
+	wg.Wait()
+
+	if firstErr == nil {
+		firstErr = ctx.Err()
+	}
+	return revoked, errors.Wrapf(firstErr, "unable to revoke %s", index)
+}
+
+// revokeBatch removes the access records accessIDs, members of the index set
+// setKey, in two pipelined round trips, one loading them and one deleting
+// them along with their set membership. The IDs of records that are gone are
+// only removed from the set. It returns how many records were deleted.
+func (s *Storage) revokeBatch(ctx context.Context, setKey string, accessIDs []string) (int, error) {
+	keys := make([]string, len(accessIDs))
+	for i, accessID := range accessIDs {
+		keys[i] = s.makeKey("access", accessID)
+	}
+	pipe := s.pool.Pipeline()
+	records := pipe.MGet(ctx, keys...)
+	refreshIDs := make([]*redis.StringSliceCmd, len(accessIDs))
+	for i, accessID := range accessIDs {
+		refreshIDs[i] = pipe.SMembers(ctx, s.makeKey("access_refresh", accessID))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, errors.Wrap(err, "unable to load access for revocation")
+	}
+
+	var (
+		deletes []*redis.Cmd
+		deleted []*osin.AccessData
+		ids     []string
+		gone    []interface{}
+	)
+	for i, value := range records.Val() {
+		raw, ok := value.(string)
+		if !ok {
+			gone = append(gone, accessIDs[i])
+			continue
+		}
+		var access osin.AccessData
+		if err := s.decode([]byte(raw), &access); err != nil {
+			return 0, keyError(keys[i], errors.Wrap(err, "failed to decode access gob"))
+		}
+		removeKeys, sets := s.removeAccessKeys(keys[i], accessIDs[i], &access, refreshIDs[i].Val())
+		// setKey is one of the record's index sets already, unless the
+		// record changed client or user; listing it again is harmless.
+		removeKeys = append([]string{removeKeys[0], setKey}, removeKeys[1:]...)
+		deletes = append(deletes, removeAccessScript.EvalSha(ctx, pipe, removeKeys, s.removeAccessArgs(keys[i], accessIDs[i], sets+1)...))
+		deleted = append(deleted, &access)
+		ids = append(ids, accessIDs[i])
+	}
+	var dropped *redis.IntCmd
+	if len(gone) > 0 {
+		dropped = pipe.SRem(ctx, setKey, gone...)
+	}
+	if len(deletes) == 0 && len(gone) == 0 {
+		return 0, nil
+	}
+	pipe.Exec(ctx)
+	if dropped != nil && dropped.Err() != nil {
+		return 0, errors.Wrap(dropped.Err(), "unable to drop expired index entries")
+	}
+
+	revoked := 0
+	gone = gone[:0]
+	for i, cmd := range deletes {
+		n, err := cmd.Int()
+		if err != nil {
+			return revoked, errors.Wrap(err, "failed to delete access")
+		}
+		if n > 0 {
+			revoked++
+			s.hooks.removeAccess(ctx, ids[i], deleted[i])
+		} else {
+			// The record expired since it was loaded.
+			gone = append(gone, ids[i])
+		}
+	}
+	if len(gone) > 0 {
+		if err := s.pool.SRem(ctx, setKey, gone...).Err(); err != nil {
+			return revoked, errors.Wrap(err, "unable to drop expired index entries")
+		}
+	}
+	return revoked, nil
+}
+
+// Revoke removes the access record behind token, which may be either an
+// access token or a refresh token, as for an RFC 7009 revocation endpoint.
+// Unknown tokens are ignored.
+func (s *Storage) Revoke(ctx context.Context, token string) (err error) {
+	ctx, done := s.begin(ctx, "Revoke")
+	defer done(&err)
+
+	err = s.removeAccessByKey(ctx, s.tokenKey("access_token", token))
+	if errors.Is(err, ErrNotFound) {
+		err = s.removeAccessByKey(ctx, s.tokenKey("refresh_token", token))
+	}
+	return ignoreNotFound(err)
+}
+
+// TokenKeys names the Redis keys behind a token, for inspection with
+// redis-cli. Keys the token does not have are empty.
+type TokenKeys struct {
+	AccessID        string // the ID of the access record
+	AccessKey       string // the access record
+	AccessFamilyKey string // the refresh token family of the record
+	AccessTokenKey  string // the access token pointer
+	RefreshTokenKey string // the refresh token pointer
+}
+
+// KeysForToken returns the keys behind token, which may be either an access
+// token or a refresh token, without modifying them. The error wraps
+// ErrNotFound if the token is unknown or has expired. If the access record
+// is gone while the pointer is not, only the keys derived from the token and
+// the access ID are filled in.
+func (s *Storage) KeysForToken(ctx context.Context, token string) (_ TokenKeys, err error) {
+	ctx, done := s.begin(ctx, "KeysForToken")
+	defer done(&err)
+
+	keys := TokenKeys{AccessTokenKey: s.tokenKey("access_token", token)}
+	keys.AccessID, err = s.getAccessID(ctx, keys.AccessTokenKey)
+	if errors.Is(err, ErrNotFound) {
+		keys = TokenKeys{RefreshTokenKey: s.tokenKey("refresh_token", token)}
+		keys.AccessID, err = s.getAccessID(ctx, keys.RefreshTokenKey)
+	}
+	if err != nil {
+		return TokenKeys{}, err
+	}
+	keys.AccessKey = s.makeKey("access", keys.AccessID)
+	keys.AccessFamilyKey = s.makeKey("access_family", keys.AccessID)
+
+	access, err := s.getAccess(ctx, keys.AccessID)
+	if errors.Is(err, ErrNotFound) {
+		return keys, nil
+	}
+	if err != nil {
+		return TokenKeys{}, err
+	}
+	if !s.statelessAccess {
+		keys.AccessTokenKey = s.tokenKey("access_token", access.AccessToken)
+	}
+	if access.RefreshToken != "" {
+		keys.RefreshTokenKey = s.tokenKey("refresh_token", access.RefreshToken)
+	}
+	return keys, nil
+}
+
+// RevokeAll removes the access records behind tokens, each of which may be an
+// access or a refresh token, and returns how many were revoked. Tokens are
+// resolved and removed in a few pipelined round trips, whatever their number.
+// Unknown and expired tokens are skipped, and the pointers of tokens whose
+// record is already gone are removed but not counted; the first other error
+// is returned once every token has been tried.
+func (s *Storage) RevokeAll(ctx context.Context, tokens []string) (_ int, err error) {
+	ctx, done := s.begin(ctx, "RevokeAll")
+	defer done(&err)
+
+	var firstErr error
+	keep := func(err error) bool {
+		if err != nil && !errors.Is(err, redis.Nil) && firstErr == nil {
+			firstErr = err
+		}
+		return err == nil
+	}
+
+	type target struct {
+		guardKey, accessID string
+		access             *osin.AccessData
+		refreshIDs         []string
+		skip               bool
+	}
+
+	// Errors are read from each queued command rather than from Exec.
+	pipe := s.pool.Pipeline()
+	var (
+		guardKeys []string
+		pointers  []*redis.StringCmd
+	)
+	for _, token := range tokens {
+		for _, key := range []string{s.tokenKey("access_token", token), s.tokenKey("refresh_token", token)} {
+			guardKeys = append(guardKeys, key)
+			pointers = append(pointers, pipe.Get(ctx, key))
+		}
+	}
+	if len(pointers) > 0 {
+		pipe.Exec(ctx)
+	}
+
+	var targets []*target
+	for i := 0; i < len(pointers); i += 2 {
+		for j := i; j < i+2; j++ {
+			if accessID, err := pointers[j].Result(); keep(err) {
+				targets = append(targets, &target{guardKey: guardKeys[j], accessID: accessID})
+				break
+			}
+		}
+	}
+	if len(targets) == 0 {
+		return 0, errors.Wrap(firstErr, "unable to get access IDs")
+	}
+
+	records := make([]*redis.StringCmd, len(targets))
+	refreshIDs := make([]*redis.StringSliceCmd, len(targets))
+	for i, t := range targets {
+		records[i] = pipe.Get(ctx, s.makeKey("access", t.accessID))
+		refreshIDs[i] = pipe.SMembers(ctx, s.makeKey("access_refresh", t.accessID))
+	}
+	pipe.Exec(ctx)
+	for i, t := range targets {
+		// As in RemoveAccess, a missing record still has its pointer
+		// removed, while one that cannot be loaded is left alone.
+		raw, err := records[i].Bytes()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		var access osin.AccessData
+		if keep(err) && keep(errors.Wrap(s.decode(raw, &access), "failed to decode access gob")) && keep(refreshIDs[i].Err()) {
+			t.access = &access
+			t.refreshIDs = refreshIDs[i].Val()
+		} else {
+			t.skip = true
+		}
+	}
+
+	if !keep(removeAccessScript.Load(ctx, s.pool).Err()) {
+		return 0, errors.Wrap(firstErr, "failed to delete access")
+	}
+	var (
+		deletes []*redis.Cmd
+		deleted []*target
+	)
+	for _, t := range targets {
+		if t.skip {
+			continue
+		}
+		keys, sets := s.removeAccessKeys(t.guardKey, t.accessID, t.access, t.refreshIDs)
+		deletes = append(deletes, removeAccessScript.EvalSha(ctx, pipe, keys, s.removeAccessArgs(t.guardKey, t.accessID, sets)...))
+		deleted = append(deleted, t)
+	}
+	if len(deletes) > 0 {
+		pipe.Exec(ctx)
+	}
+
+	revoked := 0
+	for i, cmd := range deletes {
+		// A pointer whose record is gone is removed without being counted.
+		if n, err := cmd.Int(); keep(err) && n > 0 && deleted[i].access != nil {
+			revoked++
+			s.hooks.removeAccess(ctx, deleted[i].accessID, deleted[i].access)
+		}
+	}
+	return revoked, errors.Wrap(firstErr, "failed to revoke access")
+}
+
+// TokenTTL returns how long the access token has left, without loading its
+// record. It is negative if the token does not expire, and the error wraps
+// ErrNotFound if the token is unknown or has expired.
+func (s *Storage) TokenTTL(ctx context.Context, token string) (_ time.Duration, err error) {
+	ctx, done := s.begin(ctx, "TokenTTL")
+	defer done(&err)
+	if s.statelessAccess {
+		return 0, ErrStateless
+	}
+	return s.retryPointerTTL(ctx, s.tokenKey("access_token", token))
+}
+
+// RefreshTTL is TokenTTL for a refresh token.
+func (s *Storage) RefreshTTL(ctx context.Context, token string) (_ time.Duration, err error) {
+	ctx, done := s.begin(ctx, "RefreshTTL")
+	defer done(&err)
+	return s.retryPointerTTL(ctx, s.tokenKey("refresh_token", token))
+}
+
+func (s *Storage) retryPointerTTL(ctx context.Context, key string) (ttl time.Duration, err error) {
+	err = s.retry(ctx, func() (err error) {
+		ttl, err = s.pointerTTL(ctx, key)
+		return err
+	})
+	return ttl, err
+}
+
+// pointerTTL returns the TTL of a token pointer, provided the access record it
+// resolves to still exists. The pointer's own TTL is reported because the
+// record lives as long as the longest-lived of its tokens.
+func (s *Storage) pointerTTL(ctx context.Context, key string) (time.Duration, error) {
+	accessID, err := s.getAccessID(ctx, key)
+	if err != nil {
+		return 0, err
+	}
+
+	pipe := s.pool.Pipeline()
+	ttl := pipe.TTL(ctx, key)
+	exists := pipe.Exists(ctx, s.makeKey("access", accessID))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, errors.Wrap(err, "unable to get token TTL")
+	}
+
+	// TTL reports -2 if the pointer expired since it was read.
+	if ttl.Val() == -2 || exists.Val() == 0 {
+		return 0, errors.Wrap(ErrNotFound, "unable to get token TTL")
+	}
+	return ttl.Val(), nil
+}
+
+// TouchAccess resets the expiry of the access token to d without rewriting its
+// payload, for sliding sessions. The access record is never expired earlier
+// than it already would be, so the refresh token keeps its own TTL. It returns
+// ErrNotFound if the access token has already expired.
+func (s *Storage) TouchAccess(ctx context.Context, token string, d time.Duration) (err error) {
+	ctx, done := s.begin(ctx, "TouchAccess")
+	defer done(&err)
+	if s.statelessAccess {
+		return ErrStateless
+	}
+
+	pointerKey := s.tokenKey("access_token", token)
+	accessID, err := s.getAccessID(ctx, pointerKey)
+	if err != nil {
+		return err
+	}
+	familyID, err := s.pool.Get(ctx, s.makeKey("access_family", accessID)).Result()
+	if errors.Is(err, redis.Nil) {
+		familyID = accessID
+	} else if err != nil {
+		return errors.Wrap(err, "unable to get access family")
+	}
+
+	keys := []string{
+		pointerKey,
+		s.makeKey("access", accessID),
+		s.makeKey("access_family", accessID),
+		s.makeKey("access_meta", accessID),
+		s.makeKey("access_refresh", accessID),
+		s.makeKey("access_tags", accessID),
+		s.makeKey("family", familyID),
+	}
+	touched, err := touchAccessScript.Run(ctx, s.pool, keys, d.Milliseconds()).Int()
+	if err != nil {
+		return errors.Wrap(err, "unable to touch access")
+	}
+	if touched == 0 {
+		return errors.Wrap(ErrNotFound, "unable to touch access")
+	}
+	return nil
+}
+
+// touchAccessScript sets the expiry of the access token pointer KEYS[1] to
+// ARGV[1] milliseconds and extends that of the other keys to it where they
+// would expire sooner, as EXPIRE GT does on Redis 7, leaving keys without an
+// expiry alone. It returns 0 if the pointer does not exist.
+var touchAccessScript = redis.NewScript(`
+if redis.call("PEXPIRE", KEYS[1], ARGV[1]) == 0 then
+	return 0
+end
+local ttl = tonumber(ARGV[1])
+for i = 2, #KEYS do
+	local current = redis.call("PTTL", KEYS[i])
+	if current >= 0 and current < ttl then
+		redis.call("PEXPIRE", KEYS[i], ttl)
+	end
+end
+return 1
+`)
+
+// LoadAccessBatch loads the access data of many access tokens at once, as
+// LoadAccess would, keyed by token. Tokens that do not exist or have expired
+// are absent from the result.
+//
+// The pointers and their TTLs, the access records and the clients are each
+// read in a single round trip, and each client is fetched once however many
+// tokens were issued to it.
+func (s *Storage) LoadAccessBatch(ctx context.Context, tokens []string) (_ map[string]*osin.AccessData, err error) {
+	ctx, done := s.begin(ctx, "LoadAccessBatch")
+	defer done(&err)
+	if s.statelessAccess {
+		return nil, ErrStateless
+	}
+	var result map[string]*osin.AccessData
+	err = s.retry(ctx, func() (err error) {
+		result, err = s.reader().loadAccessBatch(ctx, tokens)
+		return err
+	})
+	return result, err
+}
+
+func (s *Storage) loadAccessBatch(ctx context.Context, tokens []string) (map[string]*osin.AccessData, error) {
+	result := make(map[string]*osin.AccessData, len(tokens))
+	if len(tokens) == 0 {
+		return result, nil
+	}
+
+	keys := make([]string, len(tokens))
+	for i, token := range tokens {
+		keys[i] = s.tokenKey("access_token", token)
+	}
+	pipe := s.pool.Pipeline()
+	pointers := pipe.MGet(ctx, keys...)
+	ttls := make([]*redis.DurationCmd, len(keys))
+	for i, key := range keys {
+		ttls[i] = pipe.TTL(ctx, key)
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, errors.Wrap(err, "unable to get access IDs")
+	}
+
+	var found []string
+	var accessKeys []string
+	for i, value := range pointers.Val() {
+		accessID, ok := value.(string)
+		if !ok {
+			continue
+		}
+		found = append(found, tokens[i])
+		accessKeys = append(accessKeys, s.makeKey("access", accessID))
+	}
+	if len(accessKeys) == 0 {
+		return result, nil
+	}
+
+	values, err := s.pool.MGet(ctx, accessKeys...).Result()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to MGET access")
+	}
+	for i, value := range values {
+		raw, ok := value.(string)
+		if !ok {
+			continue
+		}
+		var access osin.AccessData
+		if err := s.decode([]byte(raw), &access); err != nil {
+			return nil, errors.Wrap(err, "failed to decode access gob")
+		}
+		result[found[i]] = &access
+	}
+
+	for i, token := range tokens {
+		access, ok := result[token]
+		if !ok {
+			continue
+		}
+		// As in LoadAccess, ExpiresIn reports the remaining life of the
+		// token; one without expiry keeps the stored value.
+		if ttl := ttls[i].Val(); ttl >= 0 {
+			access.ExpiresIn = int32(ttl.Seconds())
+		}
+	}
+
+	if !s.embeddedClient {
+		if err := s.hydrateBatchClients(ctx, result); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// hydrateBatchClients is hydrateClients for many access records, reading
+// every distinct client with a single MGET.
+func (s *Storage) hydrateBatchClients(ctx context.Context, accesses map[string]*osin.AccessData) error {
+	var ids []string
+	seen := make(map[string]bool)
+	addID := func(client osin.Client) {
+		if client != nil && !seen[client.GetId()] {
+			seen[client.GetId()] = true
+			ids = append(ids, client.GetId())
+		}
+	}
+	for _, access := range accesses {
+		addID(access.Client)
+		if access.AuthorizeData != nil {
+			addID(access.AuthorizeData.Client)
+		}
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	clients, err := s.getClients(ctx, ids)
+	if err != nil {
+		return errors.Wrap(err, "unable to get clients for access")
+	}
+	byID := make(map[string]osin.Client, len(ids))
+	for i, client := range clients {
+		if client != nil {
+			byID[ids[i]] = client
+		}
+	}
+
+	// A client that has since been deleted keeps its decoded version.
+	current := func(decoded osin.Client) osin.Client {
+		if client, ok := byID[decoded.GetId()]; ok {
+			return client
+		}
+		return decoded
+	}
+	for _, access := range accesses {
+		if access.Client != nil {
+			access.Client = current(access.Client)
+		}
+		if access.AuthorizeData != nil && access.AuthorizeData.Client != nil {
+			access.AuthorizeData.Client = current(access.AuthorizeData.Client)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,455 @@
+package osinredis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/RangelReale/osin"
+	"github.com/redis/go-redis/v9"
+	"github.com/stretchr/testify/assert"
+)
+
+func saveTestAccess(t *testing.T, storage *Storage, client osin.Client, n int) []*osin.AccessData {
+	var saved []*osin.AccessData
+	for i := 0; i < n; i++ {
+		accessData := newAccessData(newAuthorizeData(client.(*osin.DefaultClient)))
+		accessData.AccessToken = fmt.Sprintf("%s-access%d", client.GetId(), i)
+		accessData.RefreshToken = fmt.Sprintf("%s-refresh%d", client.GetId(), i)
+		assert.NoError(t, storage.SaveAccess(accessData))
+		saved = append(saved, accessData)
+	}
+	return saved
+}
+
+func TestRevokeAllForClient(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	other := newClient()
+	other.Id = "otherClientID"
+	assert.NoError(t, storage.CreateClient(other))
+
+	revokedTokens := saveTestAccess(t, storage, client, 3)
+	keptTokens := saveTestAccess(t, storage, other, 1)
+
+	revoked, err := storage.RevokeAllForClient(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 3, revoked)
+
+	for _, accessData := range revokedTokens {
+		_, err := storage.LoadAccess(accessData.AccessToken)
+		assert.ErrorIs(t, err, ErrNotFound)
+		_, err = storage.LoadRefresh(accessData.RefreshToken)
+		assert.ErrorIs(t, err, ErrNotFound)
+	}
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("client_tokens", client.GetId())).Val())
+
+	for _, accessData := range keptTokens {
+		_, err := storage.LoadAccess(accessData.AccessToken)
+		assert.NoError(t, err)
+	}
+
+	revoked, err = storage.RevokeAllForClient(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 0, revoked)
+}
+
+func TestRevokeAllForClientExpiredAccessToken(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithRefreshTTL(time.Hour))
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := newAccessData(newAuthorizeData(client))
+	assert.NoError(t, storage.SaveAccess(accessData))
+
+	// The refresh token outlives its access token.
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("access_token", accessData.AccessToken)).Err())
+
+	revoked, err := storage.RevokeAllForClient(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	_, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("client_tokens", client.GetId())).Val())
+}
+
+func TestRevokeAllForClientKeepsUnrevoked(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 2)
+	setKey := storage.makeKey("client_tokens", client.GetId())
+	corrupt, err := pool.Get(ctx, storage.makeKey("access_token", saved[0].AccessToken)).Result()
+	assert.NoError(t, err)
+	assert.NoError(t, pool.Set(ctx, storage.makeKey("access", corrupt), "garbage", time.Hour).Err())
+	assert.NoError(t, pool.SAdd(ctx, setKey, "expired").Err())
+
+	_, err = storage.RevokeAllForClient(ctx, client.GetId())
+	assert.Error(t, err)
+	assert.True(t, pool.SIsMember(ctx, setKey, corrupt).Val())
+
+	// Once the record can be revoked, the set goes with its last member.
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("access", corrupt)).Err())
+	revoked, err := storage.RevokeAllForClient(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	assert.EqualValues(t, 0, pool.Exists(ctx, setKey).Val())
+}
+
+func TestWithRevokeConcurrency(t *testing.T) {
+	flushAll()
+
+	var removed int32
+	storage := New(pool, "test123", WithRevokeConcurrency(4), WithHooks(Hooks{
+		OnRemoveAccess: func(ctx context.Context, accessID string, data *osin.AccessData) {
+			atomic.AddInt32(&removed, 1)
+		},
+	}))
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	tokens := saveTestAccess(t, storage, client, 2*revokeBatchSize+5)
+
+	revoked, err := storage.RevokeAllForClient(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, len(tokens), revoked)
+	assert.EqualValues(t, len(tokens), atomic.LoadInt32(&removed))
+	keys, err := pool.Keys(ctx, "test123:access*").Result()
+	assert.NoError(t, err)
+	assert.Empty(t, keys)
+
+	// A cancelled revocation keeps the index for a retry.
+	saveTestAccess(t, storage, client, 1)
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = storage.RevokeAllForClient(cancelled, client.GetId())
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.EqualValues(t, 1, pool.SCard(ctx, storage.makeKey("client_tokens", client.GetId())).Val())
+}
+
+func TestRevokeAllForUser(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithUserExtractor(func(userData interface{}) (string, bool) {
+		user, ok := userData.(string)
+		return user, ok && user != ""
+	}))
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 3)
+	saved[0].UserData = "alice"
+	saved[1].UserData = "alice"
+	saved[2].UserData = "bob"
+	for _, accessData := range saved {
+		assert.NoError(t, storage.SaveAccess(accessData))
+	}
+	anonymous := newAccessData(newAuthorizeData(client))
+	anonymous.AccessToken = "anonymous"
+	assert.NoError(t, storage.SaveAccess(anonymous))
+
+	assert.NoError(t, storage.RemoveAccess(saved[1].AccessToken))
+	assert.EqualValues(t, 1, pool.SCard(ctx, storage.makeKey("user_tokens", "alice")).Val())
+
+	revoked, err := storage.RevokeAllForUser(ctx, "alice")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+	_, err = storage.LoadAccess(saved[0].AccessToken)
+	assert.ErrorIs(t, err, ErrNotFound)
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("user_tokens", "alice")).Val())
+
+	_, err = storage.LoadAccess(saved[2].AccessToken)
+	assert.NoError(t, err)
+	_, err = storage.LoadAccess(anonymous.AccessToken)
+	assert.NoError(t, err)
+}
+
+func TestRemoveAccessClearsClientIndex(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 2)
+
+	setKey := storage.makeKey("client_tokens", client.GetId())
+	assert.EqualValues(t, 2, pool.SCard(ctx, setKey).Val())
+
+	assert.NoError(t, storage.RemoveAccess(saved[0].AccessToken))
+	assert.EqualValues(t, 1, pool.SCard(ctx, setKey).Val())
+
+	assert.NoError(t, storage.RemoveRefresh(saved[1].RefreshToken))
+	assert.EqualValues(t, 0, pool.SCard(ctx, setKey).Val())
+}
+
+func TestTouchAccess(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithRefreshTTL(24*time.Hour))
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessData := saveTestAccess(t, storage, client, 1)[0]
+
+	assert.NoError(t, storage.TouchAccess(ctx, accessData.AccessToken, 2*time.Hour))
+
+	ttl, err := pool.TTL(ctx, storage.makeKey("access_token", accessData.AccessToken)).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, 2*time.Hour, ttl)
+
+	ttl, err = pool.TTL(ctx, storage.makeKey("refresh_token", accessData.RefreshToken)).Result()
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, ttl)
+
+	loadData, err := storage.LoadAccess(accessData.AccessToken)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 7200, loadData.ExpiresIn)
+	loadData, err = storage.LoadRefresh(accessData.RefreshToken)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 86400, loadData.ExpiresIn)
+
+	assert.NoError(t, storage.RemoveAccess(accessData.AccessToken))
+	err = storage.TouchAccess(ctx, accessData.AccessToken, time.Hour)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestTouchAccessExtendsRecord(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessID, err := storage.SaveAccessID(ctx, newAccessData(newAuthorizeData(client)))
+	assert.NoError(t, err)
+	recordKey := storage.makeKey("access", accessID)
+
+	// The record is extended to outlive the token, but never shortened.
+	assert.NoError(t, storage.TouchAccess(ctx, "8888", 2*time.Hour))
+	assert.Equal(t, 2*time.Hour, pool.TTL(ctx, recordKey).Val())
+	assert.NoError(t, storage.TouchAccess(ctx, "8888", 30*time.Minute))
+	assert.Equal(t, 30*time.Minute, pool.TTL(ctx, storage.makeKey("access_token", "8888")).Val())
+	assert.Equal(t, 2*time.Hour, pool.TTL(ctx, recordKey).Val())
+}
+
+func TestRevoke(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 2)
+
+	assert.NoError(t, storage.Revoke(ctx, saved[0].AccessToken))
+	_, err := storage.LoadRefresh(saved[0].RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.NoError(t, storage.Revoke(ctx, saved[1].RefreshToken))
+	_, err = storage.LoadAccess(saved[1].AccessToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	assert.NoError(t, storage.Revoke(ctx, "unknown"))
+}
+
+func TestRevokeAll(t *testing.T) {
+	flushAll()
+
+	var removed []string
+	storage := New(pool, "test123", WithHooks(Hooks{
+		OnRemoveAccess: func(ctx context.Context, accessID string, data *osin.AccessData) {
+			removed = append(removed, data.AccessToken)
+		},
+	}))
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 5)
+
+	// saved[2] has expired.
+	assert.NoError(t, pool.Del(ctx,
+		storage.makeKey("access_token", saved[2].AccessToken),
+		storage.makeKey("refresh_token", saved[2].RefreshToken),
+	).Err())
+	// The record of saved[4] is gone, leaving its pointer dangling.
+	accessID, err := pool.Get(ctx, storage.makeKey("access_token", saved[4].AccessToken)).Result()
+	assert.NoError(t, err)
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("access", accessID)).Err())
+
+	revoked, err := storage.RevokeAll(ctx, []string{
+		saved[0].AccessToken,
+		saved[1].RefreshToken,
+		saved[2].AccessToken,
+		"unknown",
+		saved[0].RefreshToken,
+		saved[4].AccessToken,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, revoked)
+	assert.ElementsMatch(t, []string{saved[0].AccessToken, saved[1].AccessToken}, removed)
+	assert.EqualValues(t, 0, pool.Exists(ctx, storage.makeKey("access_token", saved[4].AccessToken)).Val())
+
+	for _, accessData := range saved[:2] {
+		_, err = storage.LoadRefresh(accessData.RefreshToken)
+		assert.True(t, errors.Is(err, ErrNotFound))
+	}
+	_, err = storage.LoadAccess(saved[3].AccessToken)
+	assert.NoError(t, err)
+
+	revoked, err = storage.RevokeAll(ctx, nil)
+	assert.NoError(t, err)
+	assert.Zero(t, revoked)
+}
+
+func TestTokenTTL(t *testing.T) {
+	flushAll()
+
+	storage := New(pool, "test123", WithRefreshTTL(24*time.Hour))
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 2)
+
+	ttl, err := storage.TokenTTL(ctx, saved[0].AccessToken)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, ttl)
+
+	ttl, err = storage.RefreshTTL(ctx, saved[0].RefreshToken)
+	assert.NoError(t, err)
+	assert.Equal(t, 24*time.Hour, ttl)
+
+	assert.NoError(t, pool.Persist(ctx, storage.makeKey("access_token", saved[0].AccessToken)).Err())
+	ttl, err = storage.TokenTTL(ctx, saved[0].AccessToken)
+	assert.NoError(t, err)
+	assert.Less(t, ttl, time.Duration(0))
+
+	_, err = storage.TokenTTL(ctx, "unknown")
+	assert.True(t, errors.Is(err, ErrNotFound))
+
+	// A pointer to a missing record counts as expired.
+	accessID, err := pool.Get(ctx, storage.makeKey("refresh_token", saved[1].RefreshToken)).Result()
+	assert.NoError(t, err)
+	assert.NoError(t, pool.Del(ctx, storage.makeKey("access", accessID)).Err())
+	_, err = storage.RefreshTTL(ctx, saved[1].RefreshToken)
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestLoadAccessBatch(t *testing.T) {
+	flushAll()
+
+	hook := &roundTripHook{}
+	counted := redis.NewClient(&redis.Options{Addr: os.Getenv("REDIS_ADDR")})
+	counted.AddHook(hook)
+	defer counted.Close()
+
+	ctx := context.Background()
+	storage := New(counted, "test123")
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	saved := saveTestAccess(t, storage, client, 3)
+
+	tokens := []string{saved[0].AccessToken, "unknown", saved[1].AccessToken, saved[2].AccessToken}
+	hook.n = 0
+	loaded, err := storage.LoadAccessBatch(ctx, tokens)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, hook.n)
+
+	assert.Len(t, loaded, 3)
+	assert.NotContains(t, loaded, "unknown")
+	for _, accessData := range saved {
+		expected, err := storage.LoadAccess(accessData.AccessToken)
+		assert.NoError(t, err)
+		// The TTL may have ticked over between the two loads.
+		assert.InDelta(t, expected.ExpiresIn, loaded[accessData.AccessToken].ExpiresIn, 1)
+		expected.ExpiresIn = loaded[accessData.AccessToken].ExpiresIn
+		assert.Equal(t, expected, loaded[accessData.AccessToken])
+	}
+
+	loaded, err = storage.LoadAccessBatch(ctx, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, loaded)
+}
+
+func TestKeysForToken(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessID, err := storage.SaveAccessID(ctx, newAccessData(newAuthorizeData(client)))
+	assert.NoError(t, err)
+
+	expected := TokenKeys{
+		AccessID:        accessID,
+		AccessKey:       "test123:access:" + accessID,
+		AccessFamilyKey: "test123:access_family:" + accessID,
+		AccessTokenKey:  "test123:access_token:8888",
+		RefreshTokenKey: "test123:refresh_token:r8888",
+	}
+	for _, token := range []string{"8888", "r8888"} {
+		keys, err := storage.KeysForToken(ctx, token)
+		assert.NoError(t, err)
+		assert.Equal(t, expected, keys)
+	}
+
+	_, err = storage.KeysForToken(ctx, "unknown")
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestRevokeAllForClientDryRun(t *testing.T) {
+	flushAll()
+
+	storage := initTestStorage()
+	ctx := context.Background()
+
+	client := newClient()
+	assert.NoError(t, storage.CreateClient(client))
+	accessID, err := storage.SaveAccessID(ctx, newAccessData(newAuthorizeData(client)))
+	assert.NoError(t, err)
+
+	keys, err := storage.RevokeAllForClientDryRun(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{
+		"test123:access_token:8888",
+		"test123:refresh_token:r8888",
+		"test123:access:" + accessID,
+		"test123:access_family:" + accessID,
+		"test123:access_refresh:" + accessID,
+		"test123:client_tokens:" + client.GetId(),
+	}, keys)
+
+	// Nothing was deleted.
+	for _, key := range keys {
+		assert.EqualValues(t, 1, pool.Exists(ctx, key).Val(), key)
+	}
+	revoked, err := storage.RevokeAllForClient(ctx, client.GetId())
+	assert.NoError(t, err)
+	assert.Equal(t, 1, revoked)
+}
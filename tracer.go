@@ -0,0 +1,92 @@
+package osinredis
+
+import "context"
+
+// Tracer starts a span around each storage operation, e.g. to trace it with
+// OpenTelemetry through the osinredisotel module. StartOp returns the context
+// the operation runs in, so that the span parents those of the Redis commands
+// it issues, and a function ending the span with the operation's error.
+//
+// op is the method name, such as "LoadAccess", and namespace the key
+// namespace it primarily works on, or "" for operations spanning several.
+type Tracer interface {
+	StartOp(ctx context.Context, op, namespace string) (context.Context, func(err error))
+}
+
+// opNamespaces maps the traced operations to their default namespace names.
+var opNamespaces = map[string]string{
+	"CreateClient":             "client",
+	"CreateClients":            "client",
+	"GetClient":                "client",
+	"GetClients":               "client",
+	"GetClientWithVersion":     "client",
+	"UpdateClient":             "client",
+	"UpdateClientCAS":          "client",
+	"DeleteClient":             "client",
+	"ListClients":              "client",
+	"ScanClients":              "client",
+	"DisableClient":            "client_disabled",
+	"EnableClient":             "client_disabled",
+	"IsClientDisabled":         "client_disabled",
+	"SetClientMeta":            "client_meta",
+	"GetClientMeta":            "client_meta",
+	"RotateClientSecret":       "client_meta",
+	"VerifyClientSecret":       "client_meta",
+	"RevokeAllForClient":       "client_tokens",
+	"RevokeAllForClientDryRun": "client_tokens",
+	"RevokeAllForUser":         "user_tokens",
+	"SaveAuthorize":            "auth",
+	"LoadAuthorize":            "auth",
+	"RemoveAuthorize":          "auth",
+	"ConsumeAuthorize":         "auth",
+	"ConsumeAuthorizeWithPKCE": "auth",
+	"CountAuthorizeCodes":      "auth",
+	"SaveAccess":               "access_token",
+	"SaveAccessWithTags":       "access_token",
+	"LoadAccess":               "access_token",
+	"LoadAccessWithTTL":        "access_token",
+	"LoadAccessBatch":          "access_token",
+	"LoadAccessLite":           "access_token",
+	"RemoveAccess":             "access_token",
+	"TouchAccess":              "access_token",
+	"KeysForToken":             "access_token",
+	"TokenTTL":                 "access_token",
+	"Introspect":               "access_token",
+	"CountAccessTokens":        "access_token",
+	"LoadRefresh":              "refresh_token",
+	"RemoveRefresh":            "refresh_token",
+	"RefreshTTL":               "refresh_token",
+	"AddRefreshToken":          "refresh_token",
+	"RotateRefresh":            "refresh_token",
+	"DetectRefreshReuse":       "refresh_consumed",
+	"RefreshFamily":            "access_family",
+	"RevokeFamily":             "family",
+	"AllowIssue":               "ratelimit",
+	"ListTokensByScope":        "scope",
+	"FindTokensByTag":          "tag",
+}
+
+// begin starts the operation op: it opens its span, if a Tracer is
+// configured, and returns the function to defer with a pointer to the
+// method's named error result, which wraps the error in an OpError, ends the
+// span and calls observe.
+func (s *Storage) begin(ctx context.Context, op string) (context.Context, func(err *error)) {
+	start := s.clock.Now()
+	if s.tracer == nil {
+		return ctx, func(err *error) {
+			*err = opError(op, *err)
+			s.observe(op, start, err)
+		}
+	}
+
+	namespace := opNamespaces[op]
+	if namespace != "" {
+		namespace = s.namespaces.name(namespace)
+	}
+	ctx, end := s.tracer.StartOp(ctx, op, namespace)
+	return ctx, func(err *error) {
+		*err = opError(op, *err)
+		end(*err)
+		s.observe(op, start, err)
+	}
+}
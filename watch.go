@@ -0,0 +1,84 @@
+package osinredis
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// watchRetryDelay is how long WatchExpirations waits before receiving again
+// after the pub/sub connection failed.
+const watchRetryDelay = time.Second
+
+// WatchExpirations sends the identifier of each access token whose key
+// expires in Redis to ch, until ctx is done, when it returns ctx's error.
+// Under WithTokenKeyHashing the identifier is the token's hash rather than
+// the token itself. Tokens removed by RemoveAccess or a revocation are not
+// reported, and under WithStatelessAccess there are no access token keys to
+// expire.
+//
+// The events come from Redis keyspace notifications, which Redis only
+// publishes with expired events enabled, e.g. with
+// "CONFIG SET notify-keyspace-events Ex". Redis publishes the event when it
+// removes the expired key, which can be some time after its TTL ran out, and
+// events published while the subscription is being reestablished are lost.
+//
+// The subscription stays open on its own connection, which is reestablished
+// and resubscribed after a network failure. Any other error ends the watch.
+func (s *Storage) WatchExpirations(ctx context.Context, ch chan<- string) error {
+	client, err := s.keyspaceClient(ctx)
+	if err != nil {
+		return opError("WatchExpirations", err)
+	}
+	db := 0
+	if c, ok := client.(*redis.Client); ok {
+		db = c.Options().DB
+	}
+	channel := fmt.Sprintf("__keyevent@%d__:expired", db)
+	pubsub := client.Subscribe(ctx, channel)
+	defer pubsub.Close()
+	// A blocked receive does not return when ctx is done; closing the
+	// subscription ends it.
+	stopped := make(chan struct{})
+	defer close(stopped)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pubsub.Close()
+		case <-stopped:
+		}
+	}()
+
+	for {
+		msg, err := pubsub.ReceiveMessage(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return opError("WatchExpirations", ctx.Err())
+			}
+			if !isTransient(err) {
+				return opError("WatchExpirations", keyError(channel, err))
+			}
+			// The next receive reconnects and resubscribes.
+			timer := time.NewTimer(watchRetryDelay)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return opError("WatchExpirations", ctx.Err())
+			case <-timer.C:
+			}
+			continue
+		}
+
+		id, ok := s.keyID("access_token", msg.Payload)
+		if !ok {
+			continue
+		}
+		select {
+		case ch <- id:
+		case <-ctx.Done():
+			return opError("WatchExpirations", ctx.Err())
+		}
+	}
+}
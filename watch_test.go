@@ -0,0 +1,57 @@
+package osinredis
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWatchExpirations(t *testing.T) {
+	flushAll()
+	ctx := context.Background()
+	storage := initTestStorage()
+
+	watchCtx, cancel := context.WithCancel(ctx)
+	ch := make(chan string, 1)
+	watched := make(chan error, 1)
+	go func() { watched <- storage.WatchExpirations(watchCtx, ch) }()
+
+	// Redis publishes these itself under notify-keyspace-events Ex; publish
+	// them directly so the test does not depend on the server's config.
+	channel := "__keyevent@0__:expired"
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, err := pool.Publish(ctx, channel, "test123:client:clientID").Result()
+		if err != nil {
+			t.Skipf("pub/sub unavailable: %v", err)
+		}
+		if n > 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("watch did not subscribe")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.NoError(t, pool.Publish(ctx, channel, "other:access_token:1").Err())
+	assert.NoError(t, pool.Publish(ctx, channel, "test123:access_token:8888").Err())
+
+	select {
+	case id := <-ch:
+		assert.Equal(t, "8888", id)
+	case err := <-watched:
+		t.Fatalf("watch ended: %v", err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("no expiry reported")
+	}
+
+	cancel()
+	err := <-watched
+	assert.True(t, errors.Is(err, context.Canceled))
+	var opErr *OpError
+	assert.True(t, errors.As(err, &opErr))
+	assert.Equal(t, "WatchExpirations", opErr.Op)
+}